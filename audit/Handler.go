@@ -0,0 +1,129 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/auth"
+	"github.com/lexffe/backend.lexffe.io/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultLimit is the number of entries returned if no limit is given, maxLimit caps the limit.
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// pages are the page sizes of the query api, applied as the settings of a collection would.
+var pages = models.CollectionSettings{DefaultLimit: defaultLimit, MaxLimit: maxLimit}
+
+// EnsureIndexes creates the indexes used by the query api.
+func (l *Log) EnsureIndexes(ctx context.Context) error {
+
+	_, err := l.DB.Collection(auditCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "collection", Value: 1}, {Key: "time", Value: -1}}},
+	})
+
+	return err
+}
+
+// RegisterRoutes registers the query api of the audit log.
+func (l *Log) RegisterRoutes(r *gin.Engine) {
+
+	// all routes should be guarded. there are no routes to modify the log.
+
+	router := r.Group("/audit", auth.CheckAuthentication("admin:audit"))
+
+	router.GET("/", l.getEntriesHandler)
+}
+
+func (l *Log) getEntriesHandler(ctx *gin.Context) {
+
+	// user-defined skip and limit, for pagination.
+
+	skip, err := strconv.ParseInt(ctx.DefaultQuery("skip", "0"), 10, 64)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("skip is not a number"))
+		ctx.Error(err)
+		return
+	}
+
+	limit, err := strconv.ParseInt(ctx.DefaultQuery("limit", "0"), 10, 64)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("limit is not a number"))
+		ctx.Error(err)
+		return
+	}
+
+	// filters: time range, actor, collection
+
+	filter := bson.M{}
+	timeRange := bson.M{}
+
+	for param, op := range map[string]string{"from": "$gte", "to": "$lt"} {
+
+		value := ctx.Query(param)
+
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New(param+" is not an RFC3339 timestamp"))
+			ctx.Error(err)
+			return
+		}
+
+		timeRange[op] = t
+	}
+
+	if len(timeRange) > 0 {
+		filter["time"] = timeRange
+	}
+
+	if actor := ctx.Query("actor"); actor != "" {
+		filter["actor"] = actor
+	}
+
+	if collection := ctx.Query("collection"); collection != "" {
+		filter["collection"] = collection
+	}
+
+	opts := options.Find().
+		SetLimit(pages.Limit(limit)).
+		SetSkip(skip).
+		SetSort(bson.M{
+			"time": -1,
+		})
+
+	cur, err := l.DB.Collection(auditCollection).Find(ctx.Request.Context(), filter, opts)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("audit: error occured at find command"))
+		ctx.Error(err)
+		return
+	}
+
+	results := []Entry{}
+
+	if err := cur.All(ctx.Request.Context(), &results); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("audit: cannot decode result"))
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, results)
+}
@@ -0,0 +1,161 @@
+package audit
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/auth"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/**
+This package records every administrative mutation (of documents, collections, users and tokens)
+in an append-only collection. Entries are never updated or deleted through the api.
+*/
+
+const auditCollection = "audit"
+
+// Action is the kind of mutation.
+type Action string
+
+const (
+	// ActionCreate is the creation of a document or collection.
+	ActionCreate Action = "create"
+	// ActionUpdate is the modification of a document or collection.
+	ActionUpdate Action = "update"
+	// ActionDelete is the deletion of a document or collection.
+	ActionDelete Action = "delete"
+	// ActionImport is the import of an archive into a collection.
+	ActionImport Action = "import"
+	// ActionPublish and ActionUnpublish are the scheduled publishing and unpublishing of a page.
+	ActionPublish   Action = "publish"
+	ActionUnpublish Action = "unpublish"
+	// ActionLoginFailed is a failed attempt to log in, the document is the user (if known).
+	ActionLoginFailed Action = "login_failed"
+)
+
+// Entry is a recorded mutation.
+type Entry struct {
+	ObjectID primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+
+	// Time is when the mutation happened.
+	Time time.Time `json:"time" bson:"time"`
+
+	// Actor is the user (or service token) who made the mutation, Session the session they used.
+	Actor   string `json:"actor" bson:"actor"`
+	Session string `json:"session,omitempty" bson:"session,omitempty"`
+
+	// Method and Route are the http method and the matched route, e.g. PUT /posts/:id
+	Method string `json:"method" bson:"method"`
+	Route  string `json:"route" bson:"route"`
+
+	Action Action `json:"action" bson:"action"`
+
+	// Collection is the target collection, Document the identifier of the target document.
+	Collection string      `json:"collection" bson:"collection"`
+	Document   interface{} `json:"document,omitempty" bson:"document,omitempty"`
+
+	// Before and After are snapshots of the target, before and after the mutation.
+	Before bson.M `json:"before,omitempty" bson:"before,omitempty"`
+	After  bson.M `json:"after,omitempty" bson:"after,omitempty"`
+
+	ClientIP string `json:"client_ip" bson:"client_ip"`
+}
+
+// Log is the audit log. A nil Log records nothing.
+type Log struct {
+	DB *mongo.Database
+}
+
+// Record appends an entry for a mutation made by the request. Before and after can be nil, or anything that
+// marshals into a bson document.
+func (l *Log) Record(ctx *gin.Context, action Action, collection string, document interface{}, before interface{}, after interface{}) {
+
+	if l == nil {
+		return
+	}
+
+	entry := Entry{
+		Time:       time.Now(),
+		Session:    ctx.GetString("Session"),
+		Method:     ctx.Request.Method,
+		Route:      ctx.FullPath(),
+		Action:     action,
+		Collection: collection,
+		Document:   document,
+		ClientIP:   auth.ClientIP(ctx),
+	}
+
+	if id, ok := auth.GetIdentity(ctx); ok {
+		entry.Actor = id.User
+	}
+
+	l.insert(entry, before, after)
+}
+
+// RecordAuth implements auth.Recorder.
+func (l *Log) RecordAuth(ctx *gin.Context, action string, collection string, document interface{}, before interface{}, after interface{}) {
+	l.Record(ctx, Action(action), collection, document, before, after)
+}
+
+// Event appends an entry for a mutation made by a background job, e.g. the scheduler. The actor names the job.
+func (l *Log) Event(actor string, action Action, collection string, document interface{}, before interface{}, after interface{}) {
+
+	if l == nil {
+		return
+	}
+
+	l.insert(Entry{
+		Time:       time.Now(),
+		Actor:      actor,
+		Action:     action,
+		Collection: collection,
+		Document:   document,
+	}, before, after)
+}
+
+// insert appends the entry with the snapshots. Failures are logged, as the mutation has already happened.
+func (l *Log) insert(entry Entry, before interface{}, after interface{}) {
+
+	var err error
+
+	if entry.Before, err = snapshot(before); err == nil {
+		entry.After, err = snapshot(after)
+	}
+
+	// not the request context: the entry is recorded even if the client has gone away
+
+	if err == nil {
+		_, err = l.DB.Collection(auditCollection).InsertOne(context.Background(), entry)
+	}
+
+	if err != nil {
+		log.Printf("audit: cannot record %v of %v/%v by %v: %v\n", entry.Action, entry.Collection, entry.Document, entry.Actor, err)
+	}
+}
+
+// snapshot converts a document into a bson.M, so that it is stored (and read back) as a plain document.
+func snapshot(v interface{}) (bson.M, error) {
+
+	if v == nil {
+		return nil, nil
+	}
+
+	b, err := bson.Marshal(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var m bson.M
+
+	if err := bson.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
@@ -6,9 +6,10 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// BearerMiddleware checks if api key exists.
+// BearerMiddleware checks the bearer token, either a signed access token or the api key of a service token.
 func (s *AuthenticateHandler) BearerMiddleware(ctx *gin.Context) {
 
 	// possible states:
@@ -21,8 +22,12 @@ func (s *AuthenticateHandler) BearerMiddleware(ctx *gin.Context) {
 
 	authHeader := ctx.GetHeader("Authorization")
 
-	// no header -> continue without auth (guest access)
+	// no header -> trusted local peer, or continue without auth (guest access)
 	if authHeader == "" {
+		if id, ok := s.Peers.identity(ctx.Request); ok {
+			ctx.Set("Authorized", true)
+			ctx.Set("Identity", id)
+		}
 		ctx.Next()
 		return
 	}
@@ -36,24 +41,51 @@ func (s *AuthenticateHandler) BearerMiddleware(ctx *gin.Context) {
 		return
 	}
 
-	// get cache
+	// access tokens are JWTs (header.payload.signature), valid as long as the session they were issued with
+
+	if strings.Count(key[1], ".") == 2 {
 
-	val, exists := s.Cache.Get("keys")
+		claims, err := s.verifyAccessToken(ctx, key[1])
 
-	// continue only if keys array exists
-	if exists == true {
+		if err != nil {
+			ctx.AbortWithError(http.StatusUnauthorized, errors.New("invalid access token"))
+			ctx.Error(err)
+			return
+		}
 
-		for i := range val.([]string) {
+		// logout, refresh, rotation, role changes and deleting the user revoke the session
 
-			// valid key
-			if val.([]string)[i] == key[1] {
-				ctx.Set("Authorized", true)
-				ctx.Next()
-				return
+		if _, err := s.Sessions.Get(ctx.Request.Context(), claims.Session); err != nil {
+			if err == ErrSessionNotFound {
+				ctx.AbortWithError(http.StatusUnauthorized, errors.New("access token has been revoked"))
+			} else {
+				ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot find session"))
+				ctx.Error(err)
 			}
-
+			return
 		}
 
+		ctx.Set("Authorized", true)
+		ctx.Set("Identity", Identity{User: claims.Subject, Role: claims.Role, Scopes: claims.Scopes})
+		ctx.Set("Session", claims.Session)
+		ctx.Next()
+		return
+	}
+
+	// not an access token, get service token
+
+	token, err := s.lookupToken(ctx.Request.Context(), key[1])
+
+	if err == nil {
+		ctx.Set("Authorized", true)
+		ctx.Set("Identity", token.identity())
+		ctx.Next()
+		return
+	}
+
+	if err != mongo.ErrNoDocuments {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
 	}
 
 	// invalid key
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/duo-labs/webauthn/webauthn"
+	"github.com/patrickmn/go-cache"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrCeremonyNotFound is returned by a CeremonyStore if the ceremony does not exist, has expired, or has been taken.
+var ErrCeremonyNotFound = errors.New("ceremony not found")
+
+// Ceremony is an unfinished webauthn registration or login.
+type Ceremony struct {
+	Challenge string               `bson:"_id"`
+	User      string               `bson:"user"`
+	Kind      string               `bson:"kind"`
+	Data      webauthn.SessionData `bson:"data"`
+	Expires   time.Time            `bson:"expires"`
+}
+
+// CeremonyStore persists the state of webauthn ceremonies between begin and finish.
+type CeremonyStore interface {
+	// Save stores a ceremony until its expiry.
+	Save(ctx context.Context, c Ceremony) error
+
+	// Take finds and removes the unexpired ceremony of a challenge, so that it can only be finished once.
+	Take(ctx context.Context, kind string, challenge string) (Ceremony, error)
+}
+
+/**
+In-memory store
+*/
+
+// MemoryCeremonyStore keeps ceremonies in process. They are not shared between instances.
+type MemoryCeremonyStore struct {
+	mu    sync.Mutex
+	Cache *cache.Cache
+}
+
+// NewMemoryCeremonyStore creates an in-memory ceremony store.
+func NewMemoryCeremonyStore() *MemoryCeremonyStore {
+	return &MemoryCeremonyStore{
+		Cache: cache.New(ceremonyTTL, 10*time.Minute),
+	}
+}
+
+// Save implements CeremonyStore.
+func (m *MemoryCeremonyStore) Save(ctx context.Context, c Ceremony) error {
+
+	ttl := time.Until(c.Expires)
+
+	// a ttl of 0 is the default expiration of the cache, negative ones never expire
+	if ttl <= 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Cache.Set(c.Challenge, c, ttl)
+
+	return nil
+}
+
+// Take implements CeremonyStore.
+func (m *MemoryCeremonyStore) Take(ctx context.Context, kind string, challenge string) (Ceremony, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	val, exists := m.Cache.Get(challenge)
+
+	if exists != true {
+		return Ceremony{}, ErrCeremonyNotFound
+	}
+
+	c := val.(Ceremony)
+
+	if c.Kind != kind {
+		return Ceremony{}, ErrCeremonyNotFound
+	}
+
+	m.Cache.Delete(challenge)
+
+	return c, nil
+}
+
+/**
+MongoDB store
+*/
+
+const ceremonyCollection = "ceremonies"
+
+// MongoCeremonyStore keeps ceremonies in the database, so that begin and finish can hit different instances.
+// Expired ceremonies are removed by a TTL index.
+type MongoCeremonyStore struct {
+	DB *mongo.Database
+}
+
+// NewMongoCeremonyStore creates a database backed ceremony store, and ensures the TTL index exists.
+func NewMongoCeremonyStore(ctx context.Context, db *mongo.Database) (*MongoCeremonyStore, error) {
+
+	_, err := db.Collection(ceremonyCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"expires": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &MongoCeremonyStore{DB: db}, nil
+}
+
+// Save implements CeremonyStore.
+func (m *MongoCeremonyStore) Save(ctx context.Context, c Ceremony) error {
+	_, err := m.DB.Collection(ceremonyCollection).InsertOne(ctx, c)
+	return err
+}
+
+// Take implements CeremonyStore.
+func (m *MongoCeremonyStore) Take(ctx context.Context, kind string, challenge string) (Ceremony, error) {
+
+	// the TTL monitor only runs every minute, so check the expiry as well.
+	res := m.DB.Collection(ceremonyCollection).FindOneAndDelete(ctx, bson.M{
+		"_id":     challenge,
+		"kind":    kind,
+		"expires": bson.M{"$gt": time.Now()},
+	})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			return Ceremony{}, ErrCeremonyNotFound
+		}
+		return Ceremony{}, res.Err()
+	}
+
+	var c Ceremony
+
+	if err := res.Decode(&c); err != nil {
+		return Ceremony{}, err
+	}
+
+	return c, nil
+}
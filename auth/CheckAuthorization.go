@@ -2,12 +2,70 @@ package auth
 
 import (
 	"net/http"
+	"regexp"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-// CheckAuthentication guards admin routes.
-func CheckAuthentication(ctx *gin.Context) {
+/**
+Scopes are in the form of <action>:<target>, e.g.
+
+read:drafts  - read unpublished pages
+write:posts  - create / update / delete documents in the "posts" collection
+admin:coll   - manage collections
+
+"*" can be used as target (write:*), or as the whole scope (everything).
+*/
+
+// scopePattern validates a scope.
+var scopePattern = regexp.MustCompile(`^(\*|(read|write|admin):(\*|[\w-]+))$`)
+
+// roleScopes are the scopes granted to users by their role.
+var roleScopes = map[Role][]string{
+	RoleAdmin:  {"*"},
+	RoleEditor: {"read:*", "write:*"},
+	RoleViewer: {"read:*"},
+}
+
+// Can reports whether the identity is granted the scope.
+func (i Identity) Can(scope string) bool {
+
+	action := strings.SplitN(scope, ":", 2)[0]
+
+	for _, granted := range i.Scopes {
+		if granted == "*" || granted == scope || granted == action+":*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasScope reports whether the request is authenticated with the scope.
+func HasScope(ctx *gin.Context, scope string) bool {
+	id, exists := GetIdentity(ctx)
+	return exists && id.Can(scope)
+}
+
+// CheckAuthentication guards admin routes. Only identities granted the scope are allowed through.
+func CheckAuthentication(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.MustGet("Authorized").(bool) == true {
+			if HasScope(ctx, scope) {
+				ctx.Next()
+				return
+			}
+			ctx.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+}
+
+// CheckIdentity guards routes that are accessible by any authenticated user.
+func CheckIdentity(ctx *gin.Context) {
 	if ctx.MustGet("Authorized").(bool) == true {
 		ctx.Next()
 		return
@@ -15,3 +73,14 @@ func CheckAuthentication(ctx *gin.Context) {
 	ctx.AbortWithStatus(http.StatusUnauthorized)
 	return
 }
+
+// GetIdentity returns the identity of the authenticated user, if any.
+func GetIdentity(ctx *gin.Context) (Identity, bool) {
+	val, exists := ctx.Get("Identity")
+
+	if exists != true {
+		return Identity{}, false
+	}
+
+	return val.(Identity), true
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unixClient is the address of clients connected over the unix socket, which have no network address.
+const unixClient = "unix"
+
+// Proxies are the reverse proxies trusted to forward the address of the client (X-Forwarded-For, X-Real-Ip).
+// The headers of other clients are ignored: the address of the client is the remote address of the connection.
+type Proxies struct {
+	networks []*net.IPNet
+	socket   bool
+}
+
+// NewProxies parses the trusted proxies: addresses, networks in CIDR notation, or "unix" for the peers of the unix
+// socket.
+func NewProxies(entries []string) (*Proxies, error) {
+
+	p := &Proxies{}
+
+	for _, entry := range entries {
+
+		if entry == unixClient {
+			p.socket = true
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+
+			if ip == nil {
+				return nil, errors.New("invalid trusted proxy: " + entry)
+			}
+
+			bits := 8 * net.IPv6len
+
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			p.networks = append(p.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+
+		if err != nil {
+			return nil, errors.New("invalid trusted proxy: " + entry)
+		}
+
+		p.networks = append(p.networks, network)
+	}
+
+	return p, nil
+}
+
+// Middleware resolves the address of the client of every request, readable with ClientIP.
+func (p *Proxies) Middleware(ctx *gin.Context) {
+	ctx.Set("ClientIP", p.clientIP(ctx.Request))
+	ctx.Next()
+}
+
+// ClientIP returns the address of the client of the request, as resolved by the Middleware of the trusted proxies.
+func ClientIP(ctx *gin.Context) string {
+
+	if ip := ctx.GetString("ClientIP"); ip != "" {
+		return ip
+	}
+
+	return remoteHost(ctx.Request)
+}
+
+// clientIP returns the address of the client: the last address forwarded by the trusted proxies in front of the
+// connection, or the remote address of the connection.
+func (p *Proxies) clientIP(req *http.Request) string {
+
+	client := remoteHost(req)
+
+	if !p.trusts(client) {
+		return client
+	}
+
+	// X-Forwarded-For is appended to by every proxy, the client is the first address not of a trusted proxy from
+	// the right
+
+	hops := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+
+	for i := len(hops) - 1; i >= 0; i-- {
+
+		hop := strings.TrimSpace(hops[i])
+
+		if net.ParseIP(hop) == nil {
+			break
+		}
+
+		client = hop
+
+		if !p.trusts(hop) {
+			return client
+		}
+	}
+
+	if ip := strings.TrimSpace(req.Header.Get("X-Real-Ip")); net.ParseIP(ip) != nil && client == remoteHost(req) {
+		return ip
+	}
+
+	return client
+}
+
+// trusts reports whether the address is a trusted proxy.
+func (p *Proxies) trusts(addr string) bool {
+
+	if p == nil {
+		return false
+	}
+
+	if addr == unixClient {
+		return p.socket
+	}
+
+	ip := net.ParseIP(addr)
+
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range p.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remoteHost returns the address of the remote end of the connection of the request.
+func remoteHost(req *http.Request) string {
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+
+	// unix socket connections have no network address
+	if err != nil || host == "" {
+		return unixClient
+	}
+
+	return host
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/helpers"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// enrolmentPath is where the enrolment of the bootstrapped admin is written to, instead of the logs.
+const enrolmentPath = ".otp-enrolment"
+
+// recoveryCodeCount is the number of recovery codes generated on enrolment.
+const recoveryCodeCount = 10
+
+// qrSize is the width and height of the QR code of the otpauth:// uri.
+const qrSize = 256
+
+// enrolment is returned once when a user is enrolled, or their secret is rotated. The secret cannot be retrieved afterwards.
+type enrolment struct {
+	User          User     `json:"user"`
+	Secret        string   `json:"secret,omitempty"`
+	URL           string   `json:"url,omitempty"`
+	QR            []byte   `json:"qr,omitempty"` // PNG, base64 encoded in json
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// enrol generates a new secret and recovery codes for the user.
+func (s *AuthenticateHandler) enrol(user *User) (enrolment, error) {
+
+	key, err := s.generateKey(user.Name)
+
+	if err != nil {
+		return enrolment{}, err
+	}
+
+	img, err := key.Image(qrSize, qrSize)
+
+	if err != nil {
+		return enrolment{}, err
+	}
+
+	var qr bytes.Buffer
+
+	if err := png.Encode(&qr, img); err != nil {
+		return enrolment{}, err
+	}
+
+	codes, err := s.generateRecoveryCodes(user)
+
+	if err != nil {
+		return enrolment{}, err
+	}
+
+	user.Secret = key.Secret()
+
+	return enrolment{
+		User:          *user,
+		Secret:        key.Secret(),
+		URL:           key.URL(),
+		QR:            qr.Bytes(),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// generateRecoveryCodes generates new recovery codes for the user. Only the hashes are kept on the user.
+func (s *AuthenticateHandler) generateRecoveryCodes(user *User) ([]string, error) {
+
+	codes := make([]string, recoveryCodeCount)
+	user.RecoveryCodes = make([]string, recoveryCodeCount)
+
+	for i := range codes {
+
+		code, err := helpers.HexStringGen(5)
+
+		if err != nil {
+			return nil, err
+		}
+
+		codes[i] = code[:5] + "-" + code[5:]
+		user.RecoveryCodes[i] = sessionID(codes[i])
+	}
+
+	return codes, nil
+}
+
+// writeEnrolment writes the enrolment to enrolmentPath, and the QR code next to it.
+func writeEnrolment(e enrolment) error {
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "user: %v\n", e.User.Name)
+
+	if e.URL != "" {
+		fmt.Fprintf(&b, "url: %v\n", e.URL)
+		fmt.Fprintf(&b, "qr: %v.png\n", enrolmentPath)
+	}
+
+	fmt.Fprintf(&b, "recovery codes:\n")
+
+	for _, code := range e.RecoveryCodes {
+		fmt.Fprintf(&b, "  %v\n", code)
+	}
+
+	if err := ioutil.WriteFile(enrolmentPath, []byte(b.String()), 0600); err != nil {
+		return err
+	}
+
+	if len(e.QR) > 0 {
+		return ioutil.WriteFile(enrolmentPath+".png", e.QR, 0600)
+	}
+
+	return nil
+}
+
+// useRecoveryCode consumes a recovery code of the user. It returns false if the code is not valid.
+func (s *AuthenticateHandler) useRecoveryCode(ctx *gin.Context, user string, code string) (bool, error) {
+
+	hash := sessionID(strings.TrimSpace(code))
+
+	// pulling the code only matches if it exists, so the code cannot be used twice.
+	res, err := s.DB.Collection(userCollection).UpdateOne(ctx.Request.Context(), bson.M{
+		"_id":            user,
+		"recovery_codes": hash,
+	}, bson.M{
+		"$pull": bson.M{"recovery_codes": hash},
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	return res.ModifiedCount == 1, nil
+}
+
+// rotateHandler issues a new secret and recovery codes for the authenticated user, and invalidates all their sessions.
+func (s *AuthenticateHandler) rotateHandler(ctx *gin.Context) {
+
+	id, _ := GetIdentity(ctx)
+
+	var user User
+
+	res := s.DB.Collection(userCollection).FindOne(ctx.Request.Context(), bson.M{"_id": id.User})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			// e.g. service tokens
+			ctx.AbortWithError(http.StatusForbidden, errors.New("only users can rotate their secret"))
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, res.Err())
+		}
+		return
+	}
+
+	if err := res.Decode(&user); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	e, err := s.enrol(&user)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot generate totp secret"))
+		ctx.Error(err)
+		return
+	}
+
+	_, err = s.DB.Collection(userCollection).UpdateOne(ctx.Request.Context(), bson.M{"_id": user.Name}, bson.M{
+		"$set": bson.M{
+			"secret":         user.Secret,
+			"recovery_codes": user.RecoveryCodes,
+		},
+	})
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot update document"))
+		ctx.Error(err)
+		return
+	}
+
+	// the secret itself is not recorded
+	s.record(ctx, recordUpdate, userCollection, user.Name, nil, nil)
+
+	// sessions issued with the old secret are no longer trusted
+
+	if err := s.Sessions.DeleteUser(ctx.Request.Context(), user.Name); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot revoke sessions"))
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, e)
+}
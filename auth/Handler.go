@@ -1,100 +1,207 @@
 package auth
 
 import (
-	"bytes"
 	"errors"
 	"io/ioutil"
+	"log"
+	"math"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/lexffe/backend.lexffe.io/helpers"
-	"github.com/patrickmn/go-cache"
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// Handler checks the otp key against the database, then returns a temporary api key valid for 2 hours
+// credentials is the body of an authentication request.
+type credentials struct {
+	User         string `json:"user"`
+	OTP          string `json:"otp" binding:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// Handler checks the otp key against the database, then returns a short-lived access token and a refresh token
 func (s *AuthenticateHandler) Handler(ctx *gin.Context) {
 
-	// Parse body: otp
-	// body should be just text, not json.
+	// Parse body: { user, otp } or { user, recovery_code } as json, or just the otp as text (for the default admin).
 
-	body, err := ioutil.ReadAll(ctx.Request.Body)
+	var body credentials
 
-	if err != nil {
-		ctx.Error(errors.New("user cannot be authenticated. reason: body cannot be parsed"))
-		ctx.AbortWithError(http.StatusInternalServerError, err)
-		return
-	}
+	if ctx.ContentType() == gin.MIMEJSON {
 
-	bodyBuf := bytes.NewBuffer(body)
+		if err := ctx.ShouldBindJSON(&body); err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed request body"))
+			ctx.Error(err)
+			return
+		}
 
-	// look for user's otp key in the db
+	} else {
 
-	f, err := os.OpenFile(otpSecretPath, os.O_RDONLY, 0600)
+		content, err := ioutil.ReadAll(ctx.Request.Body)
 
-	if err != nil {
-		ctx.Error(errors.New("user cannot be authenticated. reason: .otp file cannot be accessed"))
-		ctx.AbortWithError(http.StatusInternalServerError, err)
-		return
+		if err != nil {
+			ctx.Error(errors.New("user cannot be authenticated. reason: body cannot be parsed"))
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		body.OTP = strings.TrimSpace(string(content))
 	}
 
-	defer f.Close()
+	if body.User == "" {
+		body.User = defaultAdmin
+	}
 
-	content, err := ioutil.ReadAll(f)
+	// throttle: locked out clients and users are turned away before anything else
 
-	if err != nil {
-		ctx.Error(errors.New("user cannot be authenticated. reason: file cannot be read"))
-		ctx.AbortWithError(http.StatusInternalServerError, err)
+	if !s.attempt(ctx, ClientIP(ctx), body.User) {
 		return
 	}
 
-	if len(content) == 0 {
-		ctx.AbortWithError(http.StatusInternalServerError, errors.New("user cannot be authenticated. reason: .otp is empty"))
-		return
-	}
+	// look for user's otp key in the db
 
-	secret := bytes.NewBuffer(content)
+	var user User
 
-	totpOpts := totp.ValidateOpts{
-		Digits:    otp.DigitsEight,
-		Algorithm: otp.AlgorithmSHA512,
-	}
+	res := s.DB.Collection(userCollection).FindOne(ctx.Request.Context(), bson.M{"_id": body.User})
 
-	validated, err := totp.ValidateCustom(bodyBuf.String(), secret.String(), time.Now(), totpOpts)
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			s.reject(ctx, body.User, "unknown user")
+		} else {
+			ctx.Error(errors.New("user cannot be authenticated. reason: user cannot be retrieved"))
+			ctx.AbortWithError(http.StatusInternalServerError, res.Err())
+		}
+		return
+	}
 
-	if err != nil {
+	if err := res.Decode(&user); err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	if validated == true { // authenticated.
-		// generate apikey subroutine
+	if body.RecoveryCode != "" {
 
-		apiKey, err := helpers.HexStringGen(8)
+		// recovery codes can only be used once
+
+		used, err := s.useRecoveryCode(ctx, user.Name, body.RecoveryCode)
 
 		if err != nil {
 			ctx.AbortWithError(http.StatusInternalServerError, err)
 			return
 		}
 
-		val, exists := s.Cache.Get("keys")
+		if used != true {
+			s.reject(ctx, user.Name, "invalid recovery code")
+			return
+		}
+
+		log.Printf("auth: user %v used a recovery code from %v\n", user.Name, ClientIP(ctx))
 
-		if exists != true {
-			s.Cache.Add("keys", []string{apiKey}, cache.DefaultExpiration)
-		} else {
-			var nval = append(val.([]string), apiKey)
-			s.Cache.Set("keys", nval, cache.DefaultExpiration)
+	} else {
+
+		if len(user.Secret) == 0 {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("user cannot be authenticated. reason: secret is empty"))
+			return
+		}
+
+		totpOpts := totp.ValidateOpts{
+			Digits:    otp.DigitsEight,
+			Algorithm: otp.AlgorithmSHA512,
+		}
+
+		// malformed codes are treated the same as wrong codes
+		validated, err := totp.ValidateCustom(body.OTP, user.Secret, time.Now(), totpOpts)
+
+		if err != nil || validated != true {
+			s.reject(ctx, user.Name, "invalid code")
+			return
 		}
 
-		ctx.Header("Expires", time.Now().Add(1*time.Hour).Format(time.RFC3339))
+		// replay: a code can only be used once in its window
+
+		unused, err := s.Throttle.Use(ctx.Request.Context(), user.Name, body.OTP)
+
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		if unused != true {
+			s.reject(ctx, user.Name, "code replayed")
+			return
+		}
+	}
 
-		ctx.String(http.StatusOK, apiKey)
+	// authenticated.
+
+	s.login(ctx, user)
+}
+
+// login issues an access token and a refresh token for the authenticated user.
+func (s *AuthenticateHandler) login(ctx *gin.Context, user User) {
+
+	s.succeed(ctx, ClientIP(ctx), user.Name)
+
+	tokens, err := s.issueTokens(ctx, user.identity())
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+// attempt counts an attempt of the client on the user, either can be empty. It responds with 429 if the client or
+// the user is locked out.
+func (s *AuthenticateHandler) attempt(ctx *gin.Context, client string, user string) bool {
+
+	wait, err := s.Throttle.Attempt(ctx.Request.Context(), client, user)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot count attempt"))
+		ctx.Error(err)
+		return false
+	}
+
+	if wait > 0 {
+		s.lockedOut(ctx, wait)
+		return false
+	}
+
+	return true
+}
+
+// succeed takes back the attempts of the client on the user, as they succeeded.
+func (s *AuthenticateHandler) succeed(ctx *gin.Context, client string, user string) {
+	if err := s.Throttle.Succeed(ctx.Request.Context(), client, user); err != nil {
+		log.Printf("auth: cannot take back attempt of %v on user %v: %v\n", client, user, err)
+	}
+}
+
+// lockedOut turns away a locked out client with 429.
+func (s *AuthenticateHandler) lockedOut(ctx *gin.Context, wait time.Duration) {
+	log.Printf("auth: rejected locked out client %v, retry in %v\n", ClientIP(ctx), wait)
+	ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	ctx.AbortWithStatus(http.StatusTooManyRequests)
+}
+
+// reject records a failed attempt in the log and the audit log, and responds with 401.
+func (s *AuthenticateHandler) reject(ctx *gin.Context, user string, reason string) {
+
+	log.Printf("auth: failed attempt for user %v from %v: %v\n", user, ClientIP(ctx), reason)
+
+	var document interface{}
+
+	if user != "" {
+		document = user
+	}
+
+	s.record(ctx, recordLoginFailed, userCollection, document, nil, bson.M{"reason": reason})
+
 	ctx.AbortWithStatus(http.StatusUnauthorized)
-	return
 }
@@ -1,60 +1,125 @@
 package auth
 
 import (
+	"context"
 	"io/ioutil"
 	"log"
-
 	"os"
+	"time"
 
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // OTPInitialization initialises the OTP key for admin access
-func (s *AuthenticateHandler) OTPInitialization() error {
+func (s *AuthenticateHandler) OTPInitialization(ctx context.Context) error {
 
-	// check if database has existing OTP code. there should only be one
+	if err := s.ensureIndexes(ctx); err != nil {
+		return err
+	}
 
-	// open file with rw access, or create if it doesn't exist
-	f, err := os.OpenFile(otpSecretPath, os.O_RDWR|os.O_CREATE, 0600)
+	// there should be at least one admin. if there is none, bootstrap one.
+
+	n, err := s.DB.Collection(userCollection).CountDocuments(ctx, bson.M{"role": RoleAdmin})
 
 	if err != nil {
 		return err
 	}
 
-	defer f.Close()
+	if n > 0 {
+		return nil
+	}
+
+	admin := User{
+		Name:    defaultAdmin,
+		Role:    RoleAdmin,
+		Created: time.Now(),
+	}
 
-	content, err := ioutil.ReadAll(f)
+	// migrate the legacy .otp file if it exists
 
-	if err != nil {
+	content, err := ioutil.ReadFile(otpSecretPath)
+
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
 
-	// nothing is in the file.
-	if len(content) == 0 {
-		totpKey, err := totp.Generate(totp.GenerateOpts{
-			Issuer:      s.Issuer,
-			AccountName: "admin@" + s.Issuer,
-			Digits:      otp.DigitsEight,
-			Algorithm:   otp.AlgorithmSHA512,
-		})
+	var e enrolment
 
-		if err != nil {
-			return err
-		}
+	if len(content) > 0 {
 
-		log.Printf("new totp key: %v\n", totpKey.String())
+		// keep the secret, only generate recovery codes
 
-		_, err = f.WriteString(totpKey.Secret())
+		codes, err := s.generateRecoveryCodes(&admin)
 
 		if err != nil {
 			return err
 		}
 
-		if err := f.Sync(); err != nil {
+		admin.Secret = string(content)
+		e = enrolment{User: admin, RecoveryCodes: codes}
+
+	} else {
+
+		// nothing to migrate, generate a new secret.
+
+		if e, err = s.enrol(&admin); err != nil {
 			return err
 		}
 	}
 
+	if _, err := s.DB.Collection(userCollection).InsertOne(ctx, admin); err != nil {
+		return err
+	}
+
+	// secrets are never logged, only written to a file readable by the owner
+
+	if err := writeEnrolment(e); err != nil {
+		return err
+	}
+
+	log.Printf("enrolment of user %v written to %v\n", admin.Name, enrolmentPath)
+
+	if len(content) > 0 {
+		log.Printf("migrated %v into user %v\n", otpSecretPath, admin.Name)
+		return os.Remove(otpSecretPath)
+	}
+
 	return nil
 }
+
+// ensureIndexes creates the indexes of the auth collections.
+func (s *AuthenticateHandler) ensureIndexes(ctx context.Context) error {
+
+	// service tokens are looked up by their hash
+
+	_, err := s.DB.Collection(tokenCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"hash": 1},
+		Options: options.Index().SetUnique(true),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// webauthn: credentials are listed per user
+
+	_, err = s.DB.Collection(credentialCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.M{"user": 1},
+	})
+
+	return err
+}
+
+// generateKey generates a new TOTP key for the user.
+func (s *AuthenticateHandler) generateKey(user string) (*otp.Key, error) {
+	return totp.Generate(totp.GenerateOpts{
+		Issuer:      s.Issuer,
+		AccountName: user + "@" + s.Issuer,
+		Digits:      otp.DigitsEight,
+		Algorithm:   otp.AlgorithmSHA512,
+	})
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// accessTTL is the lifetime of an access token. Access tokens are revoked with their session, see BearerMiddleware.
+const accessTTL = 15 * time.Minute
+
+// accessClaims are the claims of an access token.
+type accessClaims struct {
+	jwt.Claims
+	Role   Role     `json:"role,omitempty"`
+	Scopes []string `json:"scopes"`
+
+	// Session is the session of the refresh token the access token was issued with.
+	Session string `json:"sid"`
+}
+
+// tokenResponse is returned on login and refresh.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// issueTokens issues a refresh token (a new session) and an access token for the identity.
+func (s *AuthenticateHandler) issueTokens(ctx *gin.Context, id Identity) (tokenResponse, error) {
+
+	key, sess, err := s.issueSession(ctx, id)
+
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	signer, err := s.Keys.signer()
+
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	now := time.Now()
+
+	claims := accessClaims{
+		Claims: jwt.Claims{
+			Issuer:   s.Issuer,
+			Subject:  id.User,
+			IssuedAt: jwt.NewNumericDate(now),
+			Expiry:   jwt.NewNumericDate(now.Add(accessTTL)),
+		},
+		Role:    id.Role,
+		Scopes:  id.Scopes,
+		Session: sess.ID,
+	}
+
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	return tokenResponse{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTTL.Seconds()),
+		RefreshToken: key,
+	}, nil
+}
+
+// verifyAccessToken checks the signature and expiry of an access token. Its session is checked by the caller.
+func (s *AuthenticateHandler) verifyAccessToken(ctx *gin.Context, token string) (accessClaims, error) {
+
+	tok, err := jwt.ParseSigned(token)
+
+	if err != nil {
+		return accessClaims{}, err
+	}
+
+	if len(tok.Headers) != 1 || !s.Keys.has(ctx.Request.Context(), tok.Headers[0].KeyID) {
+		return accessClaims{}, errors.New("unknown signing key")
+	}
+
+	var claims accessClaims
+
+	if err := tok.Claims(s.Keys.public(), &claims); err != nil {
+		return accessClaims{}, err
+	}
+
+	if err := claims.ValidateWithLeeway(jwt.Expected{Issuer: s.Issuer, Time: time.Now()}, 0); err != nil {
+		return accessClaims{}, err
+	}
+
+	return claims, nil
+}
+
+// RefreshHandler exchanges a refresh token for a new access token and refresh token. Refresh tokens can only
+// be used once: the old session is revoked.
+func (s *AuthenticateHandler) RefreshHandler(ctx *gin.Context) {
+
+	if !s.attempt(ctx, ClientIP(ctx), "") {
+		return
+	}
+
+	// body: { refresh_token }
+
+	var body struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed request body"))
+		ctx.Error(err)
+		return
+	}
+
+	// the old session is revoked in the same step: of concurrent refreshes with the same token, only one succeeds
+
+	sess, err := s.Sessions.Take(ctx.Request.Context(), sessionID(body.RefreshToken))
+
+	if err != nil {
+		if err == ErrSessionNotFound {
+			s.reject(ctx, "", "invalid refresh token")
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot revoke session"))
+			ctx.Error(err)
+		}
+		return
+	}
+
+	// the role of the user may have changed since the session was issued
+
+	var user User
+
+	res := s.DB.Collection(userCollection).FindOne(ctx.Request.Context(), bson.M{"_id": sess.User})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			s.reject(ctx, sess.User, "user no longer exists")
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, res.Err())
+		}
+		return
+	}
+
+	if err := res.Decode(&user); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	s.succeed(ctx, ClientIP(ctx), "")
+
+	tokens, err := s.issueTokens(ctx, user.identity())
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tokens)
+}
@@ -0,0 +1,336 @@
+package auth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/helpers"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const keyCollection = "signing_keys"
+
+// keyRotation is how long a signing key is used to sign new access tokens.
+const keyRotation = 24 * time.Hour
+
+// keyCheck is how often the keys are reloaded and checked for rotation, see Run. A key can sign for up to keyCheck
+// after it is due for rotation.
+const keyCheck = 10 * time.Minute
+
+// keyReload is the minimum interval between reloads of the keys caused by an unknown key id.
+const keyReload = 1 * time.Minute
+
+// signingKey is an Ed25519 key pair used to sign access tokens. The keys are kept in the database,
+// so that every instance signs and verifies with the same keys.
+type signingKey struct {
+	ID string `bson:"_id"`
+
+	// Seed is the private key seed. It is only kept in memory.
+	Seed []byte `bson:"-"`
+
+	// Sealed is the seed encrypted with the key secret, see KeySet.seal.
+	Sealed []byte `bson:"sealed"`
+
+	Created time.Time `bson:"created"`
+
+	// Expires is when the key is no longer accepted, i.e. when the last token signed with it expires.
+	Expires time.Time `bson:"expires"`
+
+	// public is derived from the seed when the key is loaded.
+	public jose.JSONWebKey
+}
+
+// KeySet holds the signing keys of access tokens. The newest key signs, every unexpired key verifies.
+type KeySet struct {
+	DB *mongo.Database
+
+	// aead encrypts the seeds in the database.
+	aead cipher.AEAD
+
+	mu     sync.RWMutex
+	keys   []signingKey // newest first
+	set    *jose.JSONWebKeySet
+	loaded time.Time
+}
+
+// NewKeySet loads the signing keys from the database, generating one if necessary. The seeds of the keys are
+// encrypted in the database with a key derived from secret, which every instance must share.
+func NewKeySet(ctx context.Context, db *mongo.Database, secret string) (*KeySet, error) {
+
+	if secret == "" {
+		return nil, errors.New("auth: no key secret")
+	}
+
+	sum := sha256.Sum256([]byte(secret))
+
+	block, err := aes.NewCipher(sum[:])
+
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// retired keys are removed once they expire
+
+	_, err = db.Collection(keyCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"expires": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// keys of previous versions were stored in plaintext, remove them
+
+	if _, err := db.Collection(keyCollection).DeleteMany(ctx, bson.M{"sealed": bson.M{"$exists": false}}); err != nil {
+		return nil, err
+	}
+
+	k := &KeySet{DB: db, aead: aead}
+
+	return k, k.Rotate(ctx)
+}
+
+// Run rotates the keys every keyCheck, until the context is cancelled.
+func (k *KeySet) Run(ctx context.Context) {
+
+	ticker := time.NewTicker(keyCheck)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.Rotate(ctx); err != nil {
+				log.Printf("auth: cannot rotate signing keys: %v\n", err)
+			}
+		}
+	}
+}
+
+// Rotate reloads the keys, and generates a new signing key if the newest one is due for rotation.
+func (k *KeySet) Rotate(ctx context.Context) error {
+
+	if err := k.load(ctx); err != nil {
+		return err
+	}
+
+	k.mu.RLock()
+	due := len(k.keys) == 0 || time.Since(k.keys[0].Created) >= keyRotation
+	k.mu.RUnlock()
+
+	if !due {
+		return nil
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		return err
+	}
+
+	id, err := helpers.HexStringGen(8)
+
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	key := signingKey{
+		ID:      id,
+		Seed:    priv.Seed(),
+		Created: now,
+		Expires: now.Add(keyRotation + keyCheck + accessTTL),
+	}
+
+	if err := k.seal(&key); err != nil {
+		return err
+	}
+
+	if _, err := k.DB.Collection(keyCollection).InsertOne(ctx, key); err != nil {
+		return err
+	}
+
+	log.Printf("auth: rotated signing key, new key id %v\n", key.ID)
+
+	return k.load(ctx)
+}
+
+// load replaces the cached keys with the unexpired keys in the database.
+func (k *KeySet) load(ctx context.Context) error {
+
+	cur, err := k.DB.Collection(keyCollection).Find(ctx, bson.M{
+		"expires": bson.M{"$gt": time.Now()},
+	}, options.Find().SetSort(bson.M{"created": -1}))
+
+	if err != nil {
+		return err
+	}
+
+	var found []signingKey
+
+	if err := cur.All(ctx, &found); err != nil {
+		return err
+	}
+
+	// keys sealed with another secret are skipped, a new key is generated if none is left
+
+	keys := []signingKey{}
+	set := &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{}}
+
+	for _, key := range found {
+
+		if err := k.open(&key); err != nil {
+			log.Printf("auth: cannot decrypt signing key %v: %v\n", key.ID, err)
+			continue
+		}
+
+		keys = append(keys, key)
+		set.Keys = append(set.Keys, key.public)
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.set = set
+	k.loaded = time.Now()
+	k.mu.Unlock()
+
+	return nil
+}
+
+// seal encrypts the seed of the key, bound to its id.
+func (k *KeySet) seal(key *signingKey) error {
+
+	nonce := make([]byte, k.aead.NonceSize())
+
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	key.Sealed = k.aead.Seal(nonce, nonce, key.Seed, []byte(key.ID))
+
+	return nil
+}
+
+// open decrypts the seed of the key.
+func (k *KeySet) open(key *signingKey) error {
+
+	n := k.aead.NonceSize()
+
+	if len(key.Sealed) < n {
+		return errors.New("sealed seed too short")
+	}
+
+	seed, err := k.aead.Open(nil, key.Sealed[:n], key.Sealed[n:], []byte(key.ID))
+
+	if err != nil {
+		return err
+	}
+
+	if len(seed) != ed25519.SeedSize {
+		return errors.New("invalid seed size")
+	}
+
+	key.Seed = seed
+	key.public = jose.JSONWebKey{
+		Key:       ed25519.NewKeyFromSeed(seed).Public(),
+		KeyID:     key.ID,
+		Algorithm: string(jose.EdDSA),
+		Use:       "sig",
+	}
+
+	return nil
+}
+
+// signer returns a signer of the newest key.
+func (k *KeySet) signer() (jose.Signer, error) {
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if len(k.keys) == 0 {
+		return nil, errors.New("no signing key")
+	}
+
+	// tokens must expire before their key: an overdue key (e.g. the database is unreachable) does not sign
+
+	if time.Since(k.keys[0].Created) > keyRotation+keyCheck {
+		return nil, errors.New("signing key is overdue for rotation")
+	}
+
+	key := jose.JSONWebKey{
+		Key:       ed25519.NewKeyFromSeed(k.keys[0].Seed),
+		KeyID:     k.keys[0].ID,
+		Algorithm: string(jose.EdDSA),
+		Use:       "sig",
+	}
+
+	return jose.NewSigner(jose.SigningKey{Algorithm: jose.EdDSA, Key: key}, (&jose.SignerOptions{}).WithType("JWT"))
+}
+
+// public returns the public keys of every unexpired key. The set must not be modified.
+func (k *KeySet) public() *jose.JSONWebKeySet {
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if k.set == nil {
+		return &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{}}
+	}
+
+	return k.set
+}
+
+// has reports whether the key id is known. Unknown key ids may have been generated by another instance,
+// so the keys are reloaded, at most once per keyReload.
+func (k *KeySet) has(ctx context.Context, id string) bool {
+
+	set := k.public()
+
+	k.mu.RLock()
+	stale := time.Since(k.loaded) > keyReload
+	k.mu.RUnlock()
+
+	if len(set.Key(id)) > 0 {
+		return true
+	}
+
+	if !stale {
+		return false
+	}
+
+	if err := k.load(ctx); err != nil {
+		log.Printf("auth: cannot reload signing keys: %v\n", err)
+		return false
+	}
+
+	return len(k.public().Key(id)) > 0
+}
+
+// Handler serves the public keys as a JSON Web Key Set, for verifying access tokens elsewhere.
+func (k *KeySet) Handler(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, k.public())
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// testKeySet returns a key set with a single key, created at the time.
+func testKeySet(t *testing.T, created time.Time) *KeySet {
+
+	block, err := aes.NewCipher(make([]byte, 32))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := &KeySet{aead: aead}
+
+	key := signingKey{ID: "test", Seed: make([]byte, ed25519.SeedSize), Created: created}
+
+	if err := k.seal(&key); err != nil {
+		t.Fatal(err)
+	}
+
+	// as loaded from the database
+
+	loaded := signingKey{ID: key.ID, Sealed: key.Sealed, Created: key.Created}
+
+	if err := k.open(&loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	k.keys = []signingKey{loaded}
+	k.set = &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{loaded.public}}
+
+	return k
+}
+
+func TestKeySetSignVerify(t *testing.T) {
+
+	k := testKeySet(t, time.Now())
+
+	signer, err := k.signer()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := jwt.Signed(signer).Claims(jwt.Claims{Subject: "alice"}).CompactSerialize()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := jwt.ParseSigned(token)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims jwt.Claims
+
+	if err := tok.Claims(k.public(), &claims); err != nil {
+		t.Fatalf("token does not verify with the public keys: %v", err)
+	}
+
+	if claims.Subject != "alice" {
+		t.Errorf("subject = %v, want alice", claims.Subject)
+	}
+}
+
+func TestKeySetOverdue(t *testing.T) {
+
+	// a key that was not rotated in time does not sign: its tokens would outlive it
+
+	k := testKeySet(t, time.Now().Add(-keyRotation-keyCheck-time.Minute))
+
+	if _, err := k.signer(); err == nil {
+		t.Errorf("overdue key signs")
+	}
+}
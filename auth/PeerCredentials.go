@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// peerPrefix distinguishes local peers from the identities of users.
+const peerPrefix = "peer:"
+
+// peerKey is the context key of the peer credentials of a connection.
+type peerKey struct{}
+
+// PeerCredentials are the credentials of the process on the other end of a unix socket.
+type PeerCredentials struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// PeerPolicy authorizes requests over the unix socket by the uid / gid of the peer process, without a token.
+//
+// A reverse proxy connecting over the socket would lend its uid to every request it forwards, so requests with
+// forwarding headers are never authorized by their peer.
+type PeerPolicy struct {
+	UIDs []uint32
+	GIDs []uint32
+
+	// Role is the role granted to allowed peers.
+	Role Role
+}
+
+// ConnContext is used as the ConnContext of the http server. It reads the peer credentials of unix socket
+// connections, once per connection.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+
+	conn, ok := c.(*net.UnixConn)
+
+	if !ok {
+		return ctx
+	}
+
+	cred, err := peerCredentials(conn)
+
+	if err != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, peerKey{}, cred)
+}
+
+// forwardingHeaders are set by reverse proxies, see PeerPolicy.
+var forwardingHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Real-Ip"}
+
+// identity returns the identity of the peer of the request, if it is allowed by the policy and the request has not
+// been forwarded.
+func (p *PeerPolicy) identity(r *http.Request) (Identity, bool) {
+
+	if p == nil {
+		return Identity{}, false
+	}
+
+	for _, header := range forwardingHeaders {
+		if _, forwarded := r.Header[header]; forwarded {
+			return Identity{}, false
+		}
+	}
+
+	cred, ok := r.Context().Value(peerKey{}).(PeerCredentials)
+
+	if !ok || !p.allows(cred) {
+		return Identity{}, false
+	}
+
+	return Identity{
+		User:   peerPrefix + strconv.FormatUint(uint64(cred.UID), 10),
+		Role:   p.Role,
+		Scopes: roleScopes[p.Role],
+	}, true
+}
+
+// allows reports whether the uid or gid of the peer is in the allow-list.
+func (p *PeerPolicy) allows(cred PeerCredentials) bool {
+
+	for _, uid := range p.UIDs {
+		if uid == cred.UID {
+			return true
+		}
+	}
+
+	for _, gid := range p.GIDs {
+		if gid == cred.GID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCredentials reads SO_PEERCRED of the socket.
+func peerCredentials(conn *net.UnixConn) (PeerCredentials, error) {
+
+	raw, err := conn.SyscallConn()
+
+	if err != nil {
+		return PeerCredentials{}, err
+	}
+
+	var ucred *syscall.Ucred
+	var credErr error
+
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+
+	if err != nil {
+		return PeerCredentials{}, err
+	}
+
+	if credErr != nil {
+		return PeerCredentials{}, credErr
+	}
+
+	return PeerCredentials{PID: ucred.Pid, UID: ucred.Uid, GID: ucred.Gid}, nil
+}
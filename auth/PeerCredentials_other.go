@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package auth
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCredentials is only supported on linux. Elsewhere, unix socket clients are treated like tcp clients.
+func peerCredentials(conn *net.UnixConn) (PeerCredentials, error) {
+	return PeerCredentials{}, errors.New("peer credentials are not supported on this platform")
+}
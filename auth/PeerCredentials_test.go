@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPeerIdentity(t *testing.T) {
+
+	policy := &PeerPolicy{UIDs: []uint32{1000}, Role: RoleEditor}
+
+	peer := func(uid uint32) context.Context {
+		return context.WithValue(context.Background(), peerKey{}, PeerCredentials{UID: uid, GID: uid})
+	}
+
+	r := httptest.NewRequest("GET", "/", nil).WithContext(peer(1000))
+
+	id, ok := policy.identity(r)
+
+	if !ok || id.User != "peer:1000" || id.Role != RoleEditor {
+		t.Errorf("identity = %+v, %v, want peer:1000", id, ok)
+	}
+
+	if _, ok := policy.identity(httptest.NewRequest("GET", "/", nil).WithContext(peer(1001))); ok {
+		t.Errorf("a peer that is not allowed was authorized")
+	}
+
+	// a reverse proxy running as an allowed uid
+	for _, header := range []string{"Forwarded", "X-Forwarded-For", "X-Real-Ip"} {
+
+		r := httptest.NewRequest("GET", "/", nil).WithContext(peer(1000))
+		r.Header.Set(header, "203.0.113.7")
+
+		if _, ok := policy.identity(r); ok {
+			t.Errorf("%v: a forwarded request was authorized by its peer", header)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Recorder records the changes to users and tokens, and failed logins, in the audit log. It is implemented by
+// audit.Log, which depends on this package.
+type Recorder interface {
+	RecordAuth(ctx *gin.Context, action string, collection string, document interface{}, before interface{}, after interface{})
+}
+
+// Actions recorded by this package, see audit.Action.
+const (
+	recordCreate = "create"
+	recordUpdate = "update"
+	recordDelete = "delete"
+
+	// recordLoginFailed is a failed attempt to log in as the user.
+	recordLoginFailed = "login_failed"
+)
+
+// record records a change in the audit log, if any.
+func (s *AuthenticateHandler) record(ctx *gin.Context, action string, collection string, document interface{}, before interface{}, after interface{}) {
+
+	if s.Audit == nil {
+		return
+	}
+
+	s.Audit.RecordAuth(ctx, action, collection, document, before, after)
+}
+
+// snapshot returns the user as recorded in the audit log, without its secret and recovery codes.
+func (u User) snapshot() bson.M {
+	return bson.M{"_id": u.Name, "role": u.Role, "created": u.Created}
+}
+
+// snapshot returns the token as recorded in the audit log, without the hash of its key.
+func (t Token) snapshot() bson.M {
+	return bson.M{"_id": t.Name, "scopes": t.Scopes, "expires": t.Expires, "created_by": t.CreatedBy, "created": t.Created}
+}
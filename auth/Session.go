@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/helpers"
+)
+
+// sessionTTL is the lifetime of a refresh token. Every refresh issues a new one.
+const sessionTTL = 24 * time.Hour
+
+// Session is an issued refresh token. The key itself is never stored, only its hash (ID).
+type Session struct {
+	ID        string `json:"_id" bson:"_id"`
+	Identity  `bson:",inline"`
+	Created   time.Time `json:"created" bson:"created"`
+	LastUsed  time.Time `json:"last_used" bson:"last_used"`
+	Expires   time.Time `json:"expires" bson:"expires"`
+	ClientIP  string    `json:"client_ip" bson:"client_ip"`
+	UserAgent string    `json:"user_agent" bson:"user_agent"`
+}
+
+// sessionID derives the session identifier from a refresh token or api key.
+func sessionID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueSession generates a new refresh token for the identity and stores its session.
+func (s *AuthenticateHandler) issueSession(ctx *gin.Context, id Identity) (string, Session, error) {
+
+	key, err := helpers.HexStringGen(16)
+
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	now := time.Now()
+
+	sess := Session{
+		ID:        sessionID(key),
+		Identity:  id,
+		Created:   now,
+		LastUsed:  now,
+		Expires:   now.Add(sessionTTL),
+		ClientIP:  ClientIP(ctx),
+		UserAgent: ctx.Request.UserAgent(),
+	}
+
+	if err := s.Sessions.Add(ctx.Request.Context(), sess); err != nil {
+		return "", Session{}, err
+	}
+
+	return key, sess, nil
+}
+
+func (s *AuthenticateHandler) logoutHandler(ctx *gin.Context) {
+
+	if err := s.Sessions.Delete(ctx.Request.Context(), ctx.GetString("Session")); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot revoke session"))
+		ctx.Error(err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (s *AuthenticateHandler) getSessionsHandler(ctx *gin.Context) {
+
+	id, _ := GetIdentity(ctx)
+
+	// admins can see every session
+
+	user := id.User
+
+	if id.Can("admin:users") {
+		user = ""
+	}
+
+	results, err := s.Sessions.List(ctx.Request.Context(), user)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot list sessions"))
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, results)
+}
+
+func (s *AuthenticateHandler) deleteSessionHandler(ctx *gin.Context) {
+
+	id, _ := GetIdentity(ctx)
+
+	sess, err := s.Sessions.Get(ctx.Request.Context(), ctx.Param("id"))
+
+	if err != nil {
+		if err == ErrSessionNotFound {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.Status(http.StatusInternalServerError)
+			ctx.Error(err)
+		}
+		return
+	}
+
+	// admins can revoke any session, users only their own
+
+	if !id.Can("admin:users") && sess.User != id.User {
+		ctx.AbortWithError(http.StatusForbidden, errors.New("session belongs to another user"))
+		return
+	}
+
+	if err := s.Sessions.Delete(ctx.Request.Context(), sess.ID); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot revoke session"))
+		ctx.Error(err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,336 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrSessionNotFound is returned by a SessionStore if the session does not exist, or has expired.
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrSessionExpired is returned by a SessionStore when adding a session that has already expired.
+var ErrSessionExpired = errors.New("session has expired")
+
+// SessionStore persists issued sessions.
+type SessionStore interface {
+	// Add stores a session until its expiry. Sessions that have already expired are refused with ErrSessionExpired.
+	Add(ctx context.Context, sess Session) error
+
+	// Get finds an unexpired session by its identifier.
+	Get(ctx context.Context, id string) (Session, error)
+
+	// Touch updates the last used timestamp of a session, without changing its expiry.
+	Touch(ctx context.Context, id string, t time.Time) error
+
+	// Take removes an unexpired session by its identifier, and returns it. Of concurrent calls with the same
+	// identifier, only one finds the session.
+	Take(ctx context.Context, id string) (Session, error)
+
+	// Delete removes a session by its identifier.
+	Delete(ctx context.Context, id string) error
+
+	// DeleteUser removes all sessions of a user.
+	DeleteUser(ctx context.Context, user string) error
+
+	// List lists all unexpired sessions. If user is not empty, only the sessions of the user are returned.
+	List(ctx context.Context, user string) ([]Session, error)
+}
+
+/**
+In-memory store
+*/
+
+// memorySessionPrefix namespaces session entries in the cache.
+const memorySessionPrefix = "session:"
+
+// MemoryStore keeps sessions in process. Sessions do not survive restarts and are not shared between instances.
+type MemoryStore struct {
+	mu    sync.Mutex
+	Cache *cache.Cache
+}
+
+// NewMemoryStore creates an in-memory session store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		Cache: cache.New(cache.NoExpiration, 10*time.Minute),
+	}
+}
+
+// Add implements SessionStore.
+func (m *MemoryStore) Add(ctx context.Context, sess Session) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.set(sess)
+}
+
+// set stores a session with its remaining ttl, the lock must be held.
+func (m *MemoryStore) set(sess Session) error {
+
+	ttl := time.Until(sess.Expires)
+
+	// a ttl of 0 is the default expiration of the cache, negative ones never expire
+	if ttl <= 0 {
+		return ErrSessionExpired
+	}
+
+	m.Cache.Set(memorySessionPrefix+sess.ID, sess, ttl)
+
+	return nil
+}
+
+// Get implements SessionStore.
+func (m *MemoryStore) Get(ctx context.Context, id string) (Session, error) {
+
+	val, exists := m.Cache.Get(memorySessionPrefix + id)
+
+	if exists != true {
+		return Session{}, ErrSessionNotFound
+	}
+
+	return val.(Session), nil
+}
+
+// Touch implements SessionStore.
+func (m *MemoryStore) Touch(ctx context.Context, id string, t time.Time) error {
+
+	// under the lock, so a session deleted meanwhile is not written back
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sess, err := m.Get(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	sess.LastUsed = t
+
+	// re-set with the remaining ttl, so the expiry of the key is unchanged.
+	if err := m.set(sess); err != nil {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// Take implements SessionStore.
+func (m *MemoryStore) Take(ctx context.Context, id string) (Session, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sess, err := m.Get(ctx, id)
+
+	if err != nil {
+		return Session{}, err
+	}
+
+	m.Cache.Delete(memorySessionPrefix + id)
+
+	return sess, nil
+}
+
+// Delete implements SessionStore.
+func (m *MemoryStore) Delete(ctx context.Context, id string) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Cache.Delete(memorySessionPrefix + id)
+
+	return nil
+}
+
+// DeleteUser implements SessionStore.
+func (m *MemoryStore) DeleteUser(ctx context.Context, user string) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sessions, _ := m.List(ctx, user)
+
+	for _, sess := range sessions {
+		m.Cache.Delete(memorySessionPrefix + sess.ID)
+	}
+
+	return nil
+}
+
+// List implements SessionStore.
+func (m *MemoryStore) List(ctx context.Context, user string) ([]Session, error) {
+
+	results := []Session{}
+
+	for k, item := range m.Cache.Items() {
+
+		if !strings.HasPrefix(k, memorySessionPrefix) {
+			continue
+		}
+
+		sess := item.Object.(Session)
+
+		if user == "" || sess.User == user {
+			results = append(results, sess)
+		}
+	}
+
+	return results, nil
+}
+
+/**
+MongoDB store
+*/
+
+const sessionCollection = "sessions"
+
+// MongoStore keeps sessions in the database. Expired sessions are removed by a TTL index.
+type MongoStore struct {
+	DB *mongo.Database
+}
+
+// NewMongoStore creates a database backed session store, and ensures the TTL index exists.
+func NewMongoStore(ctx context.Context, db *mongo.Database) (*MongoStore, error) {
+
+	_, err := db.Collection(sessionCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.M{"expires": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		{
+			Keys: bson.M{"user": 1},
+		},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &MongoStore{DB: db}, nil
+}
+
+// Add implements SessionStore.
+func (m *MongoStore) Add(ctx context.Context, sess Session) error {
+
+	if !sess.Expires.After(time.Now()) {
+		return ErrSessionExpired
+	}
+
+	_, err := m.DB.Collection(sessionCollection).InsertOne(ctx, sess)
+	return err
+}
+
+// Get implements SessionStore.
+func (m *MongoStore) Get(ctx context.Context, id string) (Session, error) {
+
+	// the TTL monitor only runs every minute, so check the expiry as well.
+	res := m.DB.Collection(sessionCollection).FindOne(ctx, bson.M{
+		"_id":     id,
+		"expires": bson.M{"$gt": time.Now()},
+	})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			return Session{}, ErrSessionNotFound
+		}
+		return Session{}, res.Err()
+	}
+
+	var sess Session
+
+	if err := res.Decode(&sess); err != nil {
+		return Session{}, err
+	}
+
+	return sess, nil
+}
+
+// Touch implements SessionStore.
+func (m *MongoStore) Touch(ctx context.Context, id string, t time.Time) error {
+
+	res, err := m.DB.Collection(sessionCollection).UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"last_used": t},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// Take implements SessionStore.
+func (m *MongoStore) Take(ctx context.Context, id string) (Session, error) {
+
+	res := m.DB.Collection(sessionCollection).FindOneAndDelete(ctx, bson.M{
+		"_id":     id,
+		"expires": bson.M{"$gt": time.Now()},
+	})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			return Session{}, ErrSessionNotFound
+		}
+		return Session{}, res.Err()
+	}
+
+	var sess Session
+
+	if err := res.Decode(&sess); err != nil {
+		return Session{}, err
+	}
+
+	return sess, nil
+}
+
+// Delete implements SessionStore.
+func (m *MongoStore) Delete(ctx context.Context, id string) error {
+	_, err := m.DB.Collection(sessionCollection).DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// DeleteUser implements SessionStore.
+func (m *MongoStore) DeleteUser(ctx context.Context, user string) error {
+	_, err := m.DB.Collection(sessionCollection).DeleteMany(ctx, bson.M{"user": user})
+	return err
+}
+
+// List implements SessionStore.
+func (m *MongoStore) List(ctx context.Context, user string) ([]Session, error) {
+
+	filter := bson.M{
+		"expires": bson.M{"$gt": time.Now()},
+	}
+
+	if user != "" {
+		filter["user"] = user
+	}
+
+	cur, err := m.DB.Collection(sessionCollection).Find(ctx, filter)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Session{}
+
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
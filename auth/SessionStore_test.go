@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	sess := Session{ID: "refresh", Identity: Identity{User: "alice"}, Expires: time.Now().Add(time.Hour)}
+
+	if err := store.Add(ctx, sess); err != nil {
+		t.Fatal(err)
+	}
+
+	// concurrent refreshes with the same token: only one takes the session
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	taken := 0
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := store.Take(ctx, sess.ID); err == nil {
+				mu.Lock()
+				taken++
+				mu.Unlock()
+			} else if err != ErrSessionNotFound {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if taken != 1 {
+		t.Errorf("taken %v times, want 1", taken)
+	}
+
+	if _, err := store.Get(ctx, sess.ID); err != ErrSessionNotFound {
+		t.Errorf("taken session: err = %v, want %v", err, ErrSessionNotFound)
+	}
+}
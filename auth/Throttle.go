@@ -0,0 +1,394 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lexffe/backend.lexffe.io/helpers"
+	"github.com/patrickmn/go-cache"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// throttleFreeAttempts is the number of failures a client can make before being locked out.
+	throttleFreeAttempts = 3
+
+	// throttleUserFreeAttempts is the number of failures on a user, from any client, before the user is locked out.
+	throttleUserFreeAttempts = 10
+
+	// throttleGlobalFreeAttempts is the number of failures, from any client on any user, before every client is
+	// locked out. It slows down guesses spread over many clients and users.
+	throttleGlobalFreeAttempts = 100
+
+	// throttleBaseDelay is the first lockout, doubled for every subsequent failure.
+	throttleBaseDelay = 1 * time.Second
+
+	// throttleMaxDelay caps the lockout.
+	throttleMaxDelay = 15 * time.Minute
+
+	// throttleReset is the period without failures after which the counters are reset.
+	throttleReset = 1 * time.Hour
+
+	// codeWindow is how long a used TOTP code is remembered, longer than the period it is valid in (30s, no skew).
+	codeWindow = 90 * time.Second
+)
+
+// Prefixes of the keys of the throttle.
+const (
+	throttleClientPrefix = "client:"
+	throttleUserPrefix   = "user:"
+	throttleCodePrefix   = "code:"
+)
+
+// throttleGlobalKey is the key of the global counter.
+const throttleGlobalKey = "global"
+
+// attempts counts the failed attempts on a key.
+type attempts struct {
+	Failures    int       `bson:"failures"`
+	LastFailure time.Time `bson:"last_failure"`
+	LockedUntil time.Time `bson:"locked_until"`
+}
+
+// wait returns how long the key is still locked out.
+func (a *attempts) wait(now time.Time) time.Duration {
+
+	if a.LockedUntil.After(now) {
+		return a.LockedUntil.Sub(now)
+	}
+
+	return 0
+}
+
+// fail records a failure, and locks out with exponential backoff when the free attempts are used up.
+func (a *attempts) fail(now time.Time, free int) {
+
+	if now.Sub(a.LastFailure) > throttleReset {
+		a.Failures = 0
+	}
+
+	a.Failures++
+	a.LastFailure = now
+
+	if a.Failures <= free {
+		return
+	}
+
+	delay := throttleMaxDelay
+
+	// avoid overflowing the shift
+	if n := uint(a.Failures - free - 1); n < 20 {
+		if d := throttleBaseDelay << n; d < throttleMaxDelay {
+			delay = d
+		}
+	}
+
+	a.LockedUntil = now.Add(delay)
+}
+
+// refund takes back a failure, of an attempt that succeeded.
+func (a *attempts) refund(free int) {
+
+	if a.Failures > 0 {
+		a.Failures--
+	}
+
+	if a.Failures <= free {
+		a.LockedUntil = time.Time{}
+	}
+}
+
+// ThrottleStore persists the counters of the throttle and the used codes.
+type ThrottleStore interface {
+	// Attempt counts an attempt on the key, in one step with the check of its lockout: if the key is locked out,
+	// it returns how long to wait, and the attempt is not counted.
+	Attempt(ctx context.Context, key string, free int) (time.Duration, error)
+
+	// Refund takes back an attempt on the key that succeeded.
+	Refund(ctx context.Context, key string, free int) error
+
+	// Use marks the key as used until it expires. It returns false if the key has already been used.
+	Use(ctx context.Context, key string, expires time.Time) (bool, error)
+}
+
+// Throttle protects the authentication endpoints against brute-force attempts.
+//
+// Every attempt is counted as a failure before it is checked, per client, per target user and globally, and taken
+// back if it succeeds: parallel attempts are counted before any of them fails, and logging in does not reset the
+// failures of guesses on another user.
+type Throttle struct {
+	Store ThrottleStore
+}
+
+// NewThrottle creates a throttle keeping its counters in the store.
+func NewThrottle(store ThrottleStore) *Throttle {
+	return &Throttle{Store: store}
+}
+
+// Attempt counts an attempt of the client on the user, either can be empty if unknown. The global counter is counted
+// with the client. It returns how long to wait if the client, the user or everyone is locked out.
+func (t *Throttle) Attempt(ctx context.Context, client string, user string) (time.Duration, error) {
+
+	if client != "" {
+		if wait, err := t.Store.Attempt(ctx, throttleClientPrefix+client, throttleFreeAttempts); wait > 0 || err != nil {
+			return wait, err
+		}
+
+		if wait, err := t.Store.Attempt(ctx, throttleGlobalKey, throttleGlobalFreeAttempts); wait > 0 || err != nil {
+			return wait, err
+		}
+	}
+
+	if user != "" {
+		return t.Store.Attempt(ctx, throttleUserPrefix+user, throttleUserFreeAttempts)
+	}
+
+	return 0, nil
+}
+
+// Succeed takes back the attempts of the client on the user, either can be empty.
+func (t *Throttle) Succeed(ctx context.Context, client string, user string) error {
+
+	if client != "" {
+		if err := t.Store.Refund(ctx, throttleClientPrefix+client, throttleFreeAttempts); err != nil {
+			return err
+		}
+
+		if err := t.Store.Refund(ctx, throttleGlobalKey, throttleGlobalFreeAttempts); err != nil {
+			return err
+		}
+	}
+
+	if user != "" {
+		return t.Store.Refund(ctx, throttleUserPrefix+user, throttleUserFreeAttempts)
+	}
+
+	return nil
+}
+
+// Use marks a code of the user as used. It returns false if the code has already been used in its window.
+func (t *Throttle) Use(ctx context.Context, user string, code string) (bool, error) {
+	return t.Store.Use(ctx, throttleCodePrefix+user+":"+code, time.Now().Add(codeWindow))
+}
+
+/**
+In-memory store
+*/
+
+// MemoryThrottleStore keeps the counters in process. They are not shared between instances.
+type MemoryThrottleStore struct {
+	mu    sync.Mutex
+	Cache *cache.Cache
+}
+
+// NewMemoryThrottleStore creates an in-memory throttle store.
+func NewMemoryThrottleStore() *MemoryThrottleStore {
+	return &MemoryThrottleStore{
+		Cache: cache.New(throttleReset, 10*time.Minute),
+	}
+}
+
+// Attempt implements ThrottleStore.
+func (m *MemoryThrottleStore) Attempt(ctx context.Context, key string, free int) (time.Duration, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	var a attempts
+
+	if val, exists := m.Cache.Get(key); exists == true {
+		a = val.(attempts)
+	}
+
+	if wait := a.wait(now); wait > 0 {
+		return wait, nil
+	}
+
+	a.fail(now, free)
+	m.Cache.Set(key, a, cache.DefaultExpiration)
+
+	return 0, nil
+}
+
+// Refund implements ThrottleStore.
+func (m *MemoryThrottleStore) Refund(ctx context.Context, key string, free int) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	val, exists := m.Cache.Get(key)
+
+	if exists != true {
+		return nil
+	}
+
+	a := val.(attempts)
+	a.refund(free)
+	m.Cache.Set(key, a, cache.DefaultExpiration)
+
+	return nil
+}
+
+// Use implements ThrottleStore.
+func (m *MemoryThrottleStore) Use(ctx context.Context, key string, expires time.Time) (bool, error) {
+
+	ttl := time.Until(expires)
+
+	// a ttl of 0 is the default expiration of the cache, negative ones never expire
+	if ttl <= 0 {
+		return true, nil
+	}
+
+	return m.Cache.Add(key, struct{}{}, ttl) == nil, nil
+}
+
+/**
+MongoDB store
+*/
+
+const throttleCollection = "throttle"
+
+// throttleEntry is the document of a key in the throttle collection.
+type throttleEntry struct {
+	Key      string    `bson:"_id"`
+	Attempts attempts  `bson:",inline"`
+	Expires  time.Time `bson:"expires"`
+}
+
+// MongoThrottleStore keeps the counters in the database, shared between instances. Expired counters and codes are
+// removed by a TTL index.
+type MongoThrottleStore struct {
+	DB *mongo.Database
+}
+
+// NewMongoThrottleStore creates a database backed throttle store, and ensures the TTL index exists.
+func NewMongoThrottleStore(ctx context.Context, db *mongo.Database) (*MongoThrottleStore, error) {
+
+	_, err := db.Collection(throttleCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"expires": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &MongoThrottleStore{DB: db}, nil
+}
+
+// Attempt implements ThrottleStore. The counter is only written if nobody (e.g. another instance) has written it
+// since it was read, otherwise it is read again.
+func (m *MongoThrottleStore) Attempt(ctx context.Context, key string, free int) (time.Duration, error) {
+
+	for {
+		entry, exists, err := m.find(ctx, key)
+
+		if err != nil {
+			return 0, err
+		}
+
+		now := time.Now()
+
+		if wait := entry.Attempts.wait(now); wait > 0 {
+			return wait, nil
+		}
+
+		previous := entry.Attempts
+
+		entry.Attempts.fail(now, free)
+		entry.Expires = entry.Attempts.LastFailure.Add(throttleReset)
+
+		if written, err := m.write(ctx, entry, previous, exists); written || err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Refund implements ThrottleStore.
+func (m *MongoThrottleStore) Refund(ctx context.Context, key string, free int) error {
+
+	for {
+		entry, exists, err := m.find(ctx, key)
+
+		if err != nil || !exists {
+			return err
+		}
+
+		previous := entry.Attempts
+
+		entry.Attempts.refund(free)
+
+		if written, err := m.write(ctx, entry, previous, exists); written || err != nil {
+			return err
+		}
+	}
+}
+
+// Use implements ThrottleStore.
+func (m *MongoThrottleStore) Use(ctx context.Context, key string, expires time.Time) (bool, error) {
+
+	// the TTL monitor only runs every minute: an expired entry is used again, an unexpired one fails the upsert
+
+	_, err := m.DB.Collection(throttleCollection).UpdateOne(ctx, bson.M{
+		"_id":     key,
+		"expires": bson.M{"$lte": time.Now()},
+	}, bson.M{
+		"$set": bson.M{"expires": expires},
+	}, options.Update().SetUpsert(true))
+
+	if err != nil {
+		if helpers.IsDuplicateKey(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// find reads the counter of a key, and reports whether it exists.
+func (m *MongoThrottleStore) find(ctx context.Context, key string) (throttleEntry, bool, error) {
+
+	entry := throttleEntry{Key: key}
+
+	err := m.DB.Collection(throttleCollection).FindOne(ctx, bson.M{"_id": key}).Decode(&entry)
+
+	if err == mongo.ErrNoDocuments {
+		return entry, false, nil
+	}
+
+	return entry, err == nil, err
+}
+
+// write writes the counter of a key, if it is still at the previous state. It reports whether it was written.
+func (m *MongoThrottleStore) write(ctx context.Context, entry throttleEntry, previous attempts, exists bool) (bool, error) {
+
+	coll := m.DB.Collection(throttleCollection)
+
+	if !exists {
+		_, err := coll.InsertOne(ctx, entry)
+
+		if helpers.IsDuplicateKey(err) {
+			return false, nil
+		}
+
+		return err == nil, err
+	}
+
+	res, err := coll.ReplaceOne(ctx, bson.M{
+		"_id":          entry.Key,
+		"failures":     previous.Failures,
+		"last_failure": previous.LastFailure,
+	}, entry)
+
+	if err != nil {
+		return false, err
+	}
+
+	return res.MatchedCount == 1, nil
+}
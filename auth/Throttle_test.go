@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func TestThrottleClientLockout(t *testing.T) {
+
+	throttle := NewThrottle(NewMemoryThrottleStore())
+	ctx := context.Background()
+
+	for i := 0; i < throttleFreeAttempts; i++ {
+		if wait, err := throttle.Attempt(ctx, "192.0.2.1", "alice"); wait > 0 || err != nil {
+			t.Fatalf("attempt %v: wait = %v, err = %v", i, wait, err)
+		}
+	}
+
+	if wait, _ := throttle.Attempt(ctx, "192.0.2.1", "alice"); wait != 0 {
+		t.Fatalf("first failure over the limit: wait = %v, want 0", wait)
+	}
+
+	if wait, _ := throttle.Attempt(ctx, "192.0.2.1", "alice"); wait == 0 {
+		t.Errorf("client is not locked out")
+	}
+
+	// other clients are not affected
+
+	if wait, _ := throttle.Attempt(ctx, "192.0.2.2", "bob"); wait != 0 {
+		t.Errorf("other client: wait = %v, want 0", wait)
+	}
+}
+
+func TestThrottleSucceed(t *testing.T) {
+
+	throttle := NewThrottle(NewMemoryThrottleStore())
+	ctx := context.Background()
+
+	// successful attempts are taken back, however many there are
+
+	for i := 0; i < 2*throttleFreeAttempts; i++ {
+
+		if wait, err := throttle.Attempt(ctx, "192.0.2.1", "alice"); wait > 0 || err != nil {
+			t.Fatalf("attempt %v: wait = %v, err = %v", i, wait, err)
+		}
+
+		if err := throttle.Succeed(ctx, "192.0.2.1", "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestThrottleGlobalLockout(t *testing.T) {
+
+	throttle := NewThrottle(NewMemoryThrottleStore())
+	ctx := context.Background()
+
+	// one guess from each client on each user, none of them is locked out
+
+	for i := 0; i <= throttleGlobalFreeAttempts; i++ {
+
+		n := strconv.Itoa(i)
+
+		if wait, err := throttle.Attempt(ctx, "client"+n, "user"+n); wait > 0 || err != nil {
+			t.Fatalf("attempt %v: wait = %v, err = %v", i, wait, err)
+		}
+	}
+
+	if wait, _ := throttle.Attempt(ctx, "another client", "another user"); wait == 0 {
+		t.Errorf("guesses spread over clients and users are not locked out")
+	}
+}
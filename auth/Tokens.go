@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/helpers"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const tokenCollection = "tokens"
+
+// tokenPrefix distinguishes service tokens from the identities of users.
+const tokenPrefix = "token:"
+
+// Token is a named, long-lived api key with a limited set of scopes, for services (e.g. CI).
+// The key itself is never stored, only its hash.
+type Token struct {
+	// Name is the unique name of the token.
+	Name string `json:"_id" bson:"_id" binding:"required"`
+
+	// Scopes are the scopes granted to the token.
+	Scopes []string `json:"scopes" bson:"scopes" binding:"required,min=1"`
+
+	// Expires is the optional expiry of the token.
+	Expires *time.Time `json:"expires,omitempty" bson:"expires,omitempty"`
+
+	// Hash is the SHA-256 of the key.
+	Hash string `json:"-" bson:"hash"`
+
+	// CreatedBy is the user who created the token.
+	CreatedBy string `json:"created_by" bson:"created_by"`
+
+	Created  time.Time `json:"created" bson:"created"`
+	LastUsed time.Time `json:"last_used,omitempty" bson:"last_used,omitempty"`
+}
+
+// lookupToken finds the unexpired token of an api key, and marks it as used.
+func (s *AuthenticateHandler) lookupToken(ctx context.Context, key string) (Token, error) {
+
+	now := time.Now()
+
+	res := s.DB.Collection(tokenCollection).FindOneAndUpdate(ctx, bson.M{
+		"hash": sessionID(key),
+		"$or": bson.A{
+			bson.M{"expires": bson.M{"$exists": false}},
+			bson.M{"expires": bson.M{"$gt": now}},
+		},
+	}, bson.M{
+		"$set": bson.M{"last_used": now},
+	})
+
+	if res.Err() != nil {
+		return Token{}, res.Err()
+	}
+
+	var token Token
+
+	if err := res.Decode(&token); err != nil {
+		return Token{}, err
+	}
+
+	return token, nil
+}
+
+// identity returns the identity of the token.
+func (t Token) identity() Identity {
+	return Identity{
+		User:   tokenPrefix + t.Name,
+		Scopes: t.Scopes,
+	}
+}
+
+func (s *AuthenticateHandler) getTokensHandler(ctx *gin.Context) {
+
+	cur, err := s.DB.Collection(tokenCollection).Find(ctx.Request.Context(), bson.M{})
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("tokens: error occured at find command"))
+		ctx.Error(err)
+		return
+	}
+
+	results := []Token{}
+
+	if err := cur.All(ctx.Request.Context(), &results); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("tokens: cannot decode result"))
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, results)
+}
+
+func (s *AuthenticateHandler) createTokenHandler(ctx *gin.Context) {
+
+	// parse body
+	// body: { _id, scopes, expires }
+
+	var body Token
+
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed request body"))
+		ctx.Error(err)
+		return
+	}
+
+	for _, scope := range body.Scopes {
+		if !scopePattern.MatchString(scope) {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid scope: "+scope))
+			return
+		}
+	}
+
+	if body.Expires != nil && body.Expires.Before(time.Now()) {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("token expires in the past"))
+		return
+	}
+
+	// generated fields: { hash, created_by, created }
+
+	key, err := helpers.HexStringGen(16)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot generate token"))
+		ctx.Error(err)
+		return
+	}
+
+	id, _ := GetIdentity(ctx)
+
+	body.Hash = sessionID(key)
+	body.CreatedBy = id.User
+	body.Created = time.Now()
+	body.LastUsed = time.Time{}
+
+	if _, err := s.DB.Collection(tokenCollection).InsertOne(ctx.Request.Context(), body); err != nil {
+		if helpers.IsDuplicateKey(err) {
+			ctx.AbortWithError(http.StatusConflict, errors.New("token already exists"))
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot insert document"))
+		}
+		ctx.Error(err)
+		return
+	}
+
+	s.record(ctx, recordCreate, tokenCollection, body.Name, nil, body.snapshot())
+
+	// the key is only returned once
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"token": body,
+		"key":   key,
+	})
+}
+
+func (s *AuthenticateHandler) deleteTokenHandler(ctx *gin.Context) {
+
+	res := s.DB.Collection(tokenCollection).FindOneAndDelete(ctx.Request.Context(), bson.M{"_id": ctx.Param("name")})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot delete document"))
+			ctx.Error(res.Err())
+		}
+		return
+	}
+
+	var before Token
+
+	if err := res.Decode(&before); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	s.record(ctx, recordDelete, tokenCollection, before.Name, before.snapshot(), nil)
+
+	ctx.Status(http.StatusNoContent)
+}
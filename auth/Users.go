@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/helpers"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RegisterRoutes registers the session and token routes, and all CRUD functions for users
+func (s *AuthenticateHandler) RegisterRoutes(r *gin.Engine) {
+
+	// all routes should be guarded.
+
+	sessions := r.Group("/auth", CheckIdentity)
+
+	sessions.DELETE("", s.logoutHandler)
+	sessions.POST("/rotate", s.rotateHandler)
+	sessions.GET("/sessions", s.getSessionsHandler)
+	sessions.DELETE("/sessions/:id", s.deleteSessionHandler)
+
+	s.registerWebAuthnRoutes(r)
+
+	tokens := r.Group("/auth/tokens", CheckAuthentication("admin:tokens"))
+
+	tokens.GET("/", s.getTokensHandler)
+	tokens.POST("/", s.createTokenHandler)
+	tokens.DELETE("/:name", s.deleteTokenHandler)
+
+	router := r.Group("/users", CheckAuthentication("admin:users"))
+
+	router.GET("/", s.getUsersHandler)
+	router.GET("/:name", s.getUserHandler)
+	router.POST("/", s.createUserHandler)
+	router.PUT("/:name", s.updateUserHandler)
+	router.DELETE("/:name", s.deleteUserHandler)
+}
+
+func (s *AuthenticateHandler) getUsersHandler(ctx *gin.Context) {
+
+	cur, err := s.DB.Collection(userCollection).Find(ctx.Request.Context(), bson.M{})
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("users: error occured at find command"))
+		ctx.Error(err)
+		return
+	}
+
+	results := []User{}
+
+	if err := cur.All(ctx.Request.Context(), &results); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("users: cannot decode result"))
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, results)
+}
+
+func (s *AuthenticateHandler) getUserHandler(ctx *gin.Context) {
+
+	res := s.DB.Collection(userCollection).FindOne(ctx.Request.Context(), bson.M{"_id": ctx.Param("name")})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.Status(http.StatusInternalServerError)
+			ctx.Error(res.Err())
+		}
+		return
+	}
+
+	var user User
+
+	if err := res.Decode(&user); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, user)
+}
+
+func (s *AuthenticateHandler) createUserHandler(ctx *gin.Context) {
+
+	// parse body
+	// body: { _id, role }
+
+	var body User
+
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed request body"))
+		ctx.Error(err)
+		return
+	}
+
+	// generated fields: { secret, recovery_codes, created }
+
+	body.Created = time.Now()
+
+	e, err := s.enrol(&body)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot generate totp secret"))
+		ctx.Error(err)
+		return
+	}
+
+	if _, err := s.DB.Collection(userCollection).InsertOne(ctx.Request.Context(), body); err != nil {
+		if helpers.IsDuplicateKey(err) {
+			ctx.AbortWithError(http.StatusConflict, errors.New("user already exists"))
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot insert document"))
+		}
+		ctx.Error(err)
+		return
+	}
+
+	s.record(ctx, recordCreate, userCollection, body.Name, nil, body.snapshot())
+
+	ctx.JSON(http.StatusCreated, e)
+}
+
+func (s *AuthenticateHandler) updateUserHandler(ctx *gin.Context) {
+
+	name := ctx.Param("name")
+
+	// parse body
+	// body: { role }
+
+	var body struct {
+		Role Role `json:"role" binding:"required,oneof=admin editor viewer"`
+	}
+
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed request body"))
+		ctx.Error(err)
+		return
+	}
+
+	// prevent demoting the last admin
+
+	if body.Role != RoleAdmin {
+		last, err := s.isLastAdmin(ctx, name)
+
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot count admins"))
+			ctx.Error(err)
+			return
+		}
+
+		if last {
+			ctx.AbortWithError(http.StatusConflict, errors.New("at least one admin is required"))
+			return
+		}
+	}
+
+	res := s.DB.Collection(userCollection).FindOneAndUpdate(ctx.Request.Context(), bson.M{"_id": name}, bson.M{
+		"$set": bson.M{"role": body.Role},
+	})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot update document"))
+			ctx.Error(res.Err())
+		}
+		return
+	}
+
+	var before User
+
+	if err := res.Decode(&before); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	after := before
+	after.Role = body.Role
+
+	s.record(ctx, recordUpdate, userCollection, name, before.snapshot(), after.snapshot())
+
+	// existing sessions carry the old role
+
+	if err := s.Sessions.DeleteUser(ctx.Request.Context(), name); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot revoke sessions"))
+		ctx.Error(err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (s *AuthenticateHandler) deleteUserHandler(ctx *gin.Context) {
+
+	name := ctx.Param("name")
+
+	// prevent deleting the last admin
+
+	last, err := s.isLastAdmin(ctx, name)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot count admins"))
+		ctx.Error(err)
+		return
+	}
+
+	if last {
+		ctx.AbortWithError(http.StatusConflict, errors.New("at least one admin is required"))
+		return
+	}
+
+	res := s.DB.Collection(userCollection).FindOneAndDelete(ctx.Request.Context(), bson.M{"_id": name})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot delete document"))
+			ctx.Error(res.Err())
+		}
+		return
+	}
+
+	var before User
+
+	if err := res.Decode(&before); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	s.record(ctx, recordDelete, userCollection, name, before.snapshot(), nil)
+
+	if _, err := s.DB.Collection(credentialCollection).DeleteMany(ctx.Request.Context(), bson.M{"user": name}); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot delete webauthn credentials"))
+		ctx.Error(err)
+		return
+	}
+
+	if err := s.Sessions.DeleteUser(ctx.Request.Context(), name); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot revoke sessions"))
+		ctx.Error(err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// isLastAdmin reports whether no admin other than the user exists.
+func (s *AuthenticateHandler) isLastAdmin(ctx *gin.Context, name string) (bool, error) {
+
+	n, err := s.DB.Collection(userCollection).CountDocuments(ctx.Request.Context(), bson.M{
+		"role": RoleAdmin,
+		"_id":  bson.M{"$ne": name},
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	return n == 0, nil
+}
@@ -0,0 +1,461 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/duo-labs/webauthn/protocol"
+	"github.com/duo-labs/webauthn/webauthn"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/**
+WebAuthn (passkey) login, as an alternative to TOTP.
+
+Registration (authenticated):
+	POST /auth/webauthn/register/begin  -> PublicKeyCredentialCreationOptions
+	POST /auth/webauthn/register/finish <- navigator.credentials.create() response
+
+Login:
+	POST /auth/webauthn/login/begin     <- { user }, -> PublicKeyCredentialRequestOptions
+	POST /auth/webauthn/login/finish    <- navigator.credentials.get() response, -> api key (same as POST /auth)
+
+A login is one attempt of the throttle, counted at finish: on the client, then on the user of the ceremony.
+
+The state of a ceremony is kept in the CeremonyStore under its challenge, next to the sessions: with the mongo store,
+begin and finish can hit different instances.
+*/
+
+const credentialCollection = "credentials"
+
+// ceremonyTTL is how long a ceremony can take between begin and finish.
+const ceremonyTTL = 5 * time.Minute
+
+const (
+	ceremonyRegister = "register"
+	ceremonyLogin    = "login"
+)
+
+// Passkey is a WebAuthn credential registered by a user.
+type Passkey struct {
+	// ID is the base64url encoded credential id.
+	ID string `json:"_id" bson:"_id"`
+
+	// User is the owner of the credential.
+	User string `json:"user" bson:"user"`
+
+	// Credential is the public key and authenticator of the credential.
+	Credential webauthn.Credential `json:"-" bson:"credential"`
+
+	Created  time.Time `json:"created" bson:"created"`
+	LastUsed time.Time `json:"last_used" bson:"last_used"`
+}
+
+var (
+	// errUnverified wraps the errors of responses that cannot be verified.
+	errUnverified = errors.New("webauthn response cannot be verified")
+
+	// errCloned is returned for an assertion whose signature counter went backwards.
+	errCloned = errors.New("webauthn signature counter mismatch")
+)
+
+// webauthnUser adapts a user and their credentials to webauthn.User
+type webauthnUser struct {
+	User
+	credentials []webauthn.Credential
+}
+
+func (u webauthnUser) WebAuthnID() []byte                         { return []byte(u.Name) }
+func (u webauthnUser) WebAuthnName() string                       { return u.Name }
+func (u webauthnUser) WebAuthnDisplayName() string                { return u.Name }
+func (u webauthnUser) WebAuthnIcon() string                       { return "" }
+func (u webauthnUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+// registerWebAuthnRoutes registers the webauthn routes, if webauthn is configured.
+func (s *AuthenticateHandler) registerWebAuthnRoutes(r *gin.Engine) {
+
+	if s.WebAuthn == nil {
+		return
+	}
+
+	router := r.Group("/auth/webauthn")
+
+	router.POST("/login/begin", s.beginLoginHandler)
+	router.POST("/login/finish", s.finishLoginHandler)
+
+	protected := router.Group("/", CheckIdentity)
+
+	protected.POST("/register/begin", s.beginRegistrationHandler)
+	protected.POST("/register/finish", s.finishRegistrationHandler)
+	protected.GET("/credentials", s.getPasskeysHandler)
+	protected.DELETE("/credentials/:id", s.deletePasskeyHandler)
+}
+
+// loadWebAuthnUser finds the user and their credentials.
+func (s *AuthenticateHandler) loadWebAuthnUser(ctx context.Context, name string) (webauthnUser, error) {
+
+	var u webauthnUser
+
+	res := s.DB.Collection(userCollection).FindOne(ctx, bson.M{"_id": name})
+
+	if err := res.Decode(&u.User); err != nil {
+		return webauthnUser{}, err
+	}
+
+	cur, err := s.DB.Collection(credentialCollection).Find(ctx, bson.M{"user": name})
+
+	if err != nil {
+		return webauthnUser{}, err
+	}
+
+	var passkeys []Passkey
+
+	if err := cur.All(ctx, &passkeys); err != nil {
+		return webauthnUser{}, err
+	}
+
+	for _, p := range passkeys {
+		u.credentials = append(u.credentials, p.Credential)
+	}
+
+	return u, nil
+}
+
+// saveCeremony stores the state of a ceremony until it is finished, or expires.
+func (s *AuthenticateHandler) saveCeremony(ctx context.Context, kind string, user string, data *webauthn.SessionData) error {
+	return s.Ceremonies.Save(ctx, Ceremony{
+		Challenge: data.Challenge,
+		User:      user,
+		Kind:      kind,
+		Data:      *data,
+		Expires:   time.Now().Add(ceremonyTTL),
+	})
+}
+
+// beginRegistration starts the registration of a credential of the user.
+func (s *AuthenticateHandler) beginRegistration(ctx context.Context, u webauthnUser) (*protocol.CredentialCreation, error) {
+
+	// do not register the same authenticator twice
+
+	exclusions := make([]protocol.CredentialDescriptor, len(u.credentials))
+
+	for i, c := range u.credentials {
+		exclusions[i] = protocol.CredentialDescriptor{
+			Type:         protocol.PublicKeyCredentialType,
+			CredentialID: c.ID,
+		}
+	}
+
+	options, data, err := s.WebAuthn.BeginRegistration(u, webauthn.WithExclusions(exclusions))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.saveCeremony(ctx, ceremonyRegister, u.Name, data); err != nil {
+		return nil, err
+	}
+
+	return options, nil
+}
+
+// finishRegistration verifies the attestation of a registration of the user. The ceremony is taken, it cannot be
+// finished twice.
+func (s *AuthenticateHandler) finishRegistration(ctx context.Context, u webauthnUser, parsed *protocol.ParsedCredentialCreationData) (*webauthn.Credential, error) {
+
+	c, err := s.Ceremonies.Take(ctx, ceremonyRegister, parsed.Response.CollectedClientData.Challenge)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if c.User != u.Name {
+		return nil, ErrCeremonyNotFound
+	}
+
+	credential, err := s.WebAuthn.CreateCredential(u, c.Data, parsed)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnverified, err)
+	}
+
+	return credential, nil
+}
+
+// beginLogin starts a login of the user.
+func (s *AuthenticateHandler) beginLogin(ctx context.Context, u webauthnUser) (*protocol.CredentialAssertion, error) {
+
+	options, data, err := s.WebAuthn.BeginLogin(u)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.saveCeremony(ctx, ceremonyLogin, u.Name, data); err != nil {
+		return nil, err
+	}
+
+	return options, nil
+}
+
+// validateLogin verifies the assertion of a login of the user, in a ceremony taken by the caller.
+func (s *AuthenticateHandler) validateLogin(u webauthnUser, c Ceremony, parsed *protocol.ParsedCredentialAssertionData) (*webauthn.Credential, error) {
+
+	credential, err := s.WebAuthn.ValidateLogin(u, c.Data, parsed)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnverified, err)
+	}
+
+	// a signature counter going backwards means the authenticator may be cloned
+
+	if credential.Authenticator.CloneWarning {
+		return nil, errCloned
+	}
+
+	return credential, nil
+}
+
+func (s *AuthenticateHandler) beginRegistrationHandler(ctx *gin.Context) {
+
+	id, _ := GetIdentity(ctx)
+
+	u, err := s.loadWebAuthnUser(ctx.Request.Context(), id.User)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			// e.g. service tokens
+			ctx.AbortWithError(http.StatusForbidden, errors.New("only users can register credentials"))
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	options, err := s.beginRegistration(ctx.Request.Context(), u)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot begin registration"))
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, options)
+}
+
+func (s *AuthenticateHandler) finishRegistrationHandler(ctx *gin.Context) {
+
+	id, _ := GetIdentity(ctx)
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(ctx.Request.Body)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed request body"))
+		ctx.Error(err)
+		return
+	}
+
+	u, err := s.loadWebAuthnUser(ctx.Request.Context(), id.User)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.AbortWithError(http.StatusForbidden, errors.New("only users can register credentials"))
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	credential, err := s.finishRegistration(ctx.Request.Context(), u, parsed)
+
+	if err != nil {
+		if err == ErrCeremonyNotFound {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("unknown or expired ceremony"))
+		} else if errors.Is(err, errUnverified) {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("credential cannot be verified"))
+			ctx.Error(err)
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	passkey := Passkey{
+		ID:         base64.RawURLEncoding.EncodeToString(credential.ID),
+		User:       u.Name,
+		Credential: *credential,
+		Created:    time.Now(),
+	}
+
+	if _, err := s.DB.Collection(credentialCollection).InsertOne(ctx.Request.Context(), passkey); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot insert document"))
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, passkey)
+}
+
+func (s *AuthenticateHandler) beginLoginHandler(ctx *gin.Context) {
+
+	var body struct {
+		User string `json:"user" binding:"required"`
+	}
+
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed request body"))
+		ctx.Error(err)
+		return
+	}
+
+	// only the assertion is an attempt to log in, it is counted at finish
+
+	u, err := s.loadWebAuthnUser(ctx.Request.Context(), body.User)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			s.reject(ctx, body.User, "unknown user")
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if len(u.credentials) == 0 {
+		s.reject(ctx, body.User, "no webauthn credentials")
+		return
+	}
+
+	options, err := s.beginLogin(ctx.Request.Context(), u)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot begin login"))
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, options)
+}
+
+func (s *AuthenticateHandler) finishLoginHandler(ctx *gin.Context) {
+
+	if !s.attempt(ctx, ClientIP(ctx), "") {
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(ctx.Request.Body)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed request body"))
+		ctx.Error(err)
+		return
+	}
+
+	c, err := s.Ceremonies.Take(ctx.Request.Context(), ceremonyLogin, parsed.Response.CollectedClientData.Challenge)
+
+	if err != nil {
+		if err == ErrCeremonyNotFound {
+			s.reject(ctx, "", "unknown or expired webauthn ceremony")
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	// the user is only known from the ceremony
+
+	if !s.attempt(ctx, "", c.User) {
+		return
+	}
+
+	u, err := s.loadWebAuthnUser(ctx.Request.Context(), c.User)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	credential, err := s.validateLogin(u, c, parsed)
+
+	if err != nil {
+		if err == errCloned {
+			s.reject(ctx, u.Name, "webauthn signature counter mismatch")
+		} else {
+			ctx.Error(err)
+			s.reject(ctx, u.Name, "invalid webauthn assertion")
+		}
+		return
+	}
+
+	_, err = s.DB.Collection(credentialCollection).UpdateOne(ctx.Request.Context(), bson.M{
+		"_id": base64.RawURLEncoding.EncodeToString(credential.ID),
+	}, bson.M{
+		"$set": bson.M{
+			"credential.authenticator.signcount": credential.Authenticator.SignCount,
+			"last_used":                          time.Now(),
+		},
+	})
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot update document"))
+		ctx.Error(err)
+		return
+	}
+
+	log.Printf("auth: user %v logged in with webauthn from %v\n", u.Name, ClientIP(ctx))
+
+	s.login(ctx, u.User)
+}
+
+func (s *AuthenticateHandler) getPasskeysHandler(ctx *gin.Context) {
+
+	id, _ := GetIdentity(ctx)
+
+	cur, err := s.DB.Collection(credentialCollection).Find(ctx.Request.Context(), bson.M{"user": id.User})
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("credentials: error occured at find command"))
+		ctx.Error(err)
+		return
+	}
+
+	results := []Passkey{}
+
+	if err := cur.All(ctx.Request.Context(), &results); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("credentials: cannot decode result"))
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, results)
+}
+
+func (s *AuthenticateHandler) deletePasskeyHandler(ctx *gin.Context) {
+
+	id, _ := GetIdentity(ctx)
+
+	res, err := s.DB.Collection(credentialCollection).DeleteOne(ctx.Request.Context(), bson.M{
+		"_id":  ctx.Param("id"),
+		"user": id.User,
+	})
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot delete document"))
+		ctx.Error(err)
+		return
+	}
+
+	if res.DeletedCount == 0 {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
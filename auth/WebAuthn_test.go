@@ -0,0 +1,590 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/duo-labs/webauthn/protocol"
+	"github.com/duo-labs/webauthn/webauthn"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+const (
+	testRPID     = "localhost"
+	testRPOrigin = "https://localhost"
+)
+
+// softAuthenticator is a software authenticator with a single P-256 credential and "none" attestation.
+type softAuthenticator struct {
+	id     []byte
+	key    *ecdsa.PrivateKey
+	count  uint32
+	origin string
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := make([]byte, 16)
+
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{id: id, key: key, origin: testRPOrigin}
+}
+
+// authData returns the authenticator data, with the attested credential if attested is set.
+func (a *softAuthenticator) authData(attested bool) []byte {
+
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+
+	// user present, user verified
+	flags := byte(0x01 | 0x04)
+
+	if attested {
+		flags |= 0x40
+	}
+
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	data = append(data, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[len(data)-4:], a.count)
+
+	if !attested {
+		return data
+	}
+
+	// aaguid, credential id, COSE EC2 key (kty: EC2, alg: ES256, crv: P-256, x, y)
+
+	data = append(data, make([]byte, 16)...)
+	data = append(data, byte(len(a.id)>>8), byte(len(a.id)))
+	data = append(data, a.id...)
+
+	point := elliptic.Marshal(elliptic.P256(), a.key.X, a.key.Y)
+	x, y := point[1:33], point[33:]
+
+	return append(data, cborMap(
+		cborInt(1), cborInt(2),
+		cborInt(3), cborInt(-7),
+		cborInt(-1), cborInt(1),
+		cborInt(-2), cborBytes(x),
+		cborInt(-3), cborBytes(y),
+	)...)
+}
+
+// clientData returns the client data of a ceremony.
+func (a *softAuthenticator) clientData(ceremony protocol.CeremonyType, challenge protocol.Challenge) []byte {
+
+	data, _ := json.Marshal(map[string]string{
+		"type":      string(ceremony),
+		"challenge": challenge.String(),
+		"origin":    a.origin,
+	})
+
+	return data
+}
+
+// create answers navigator.credentials.create().
+func (a *softAuthenticator) create(t *testing.T, options *protocol.CredentialCreation) *protocol.ParsedCredentialCreationData {
+
+	a.count++
+
+	attestation := cborMap(
+		cborText("fmt"), cborText("none"),
+		cborText("attStmt"), cborMap(),
+		cborText("authData"), cborBytes(a.authData(true)),
+	)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":    base64.RawURLEncoding.EncodeToString(a.id),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(a.clientData(protocol.CreateCeremony, options.Response.Challenge)),
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestation),
+		},
+	})
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(body))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return parsed
+}
+
+// assert returns the response body of navigator.credentials.get().
+func (a *softAuthenticator) assert(t *testing.T, options *protocol.CredentialAssertion) []byte {
+
+	a.count++
+
+	authData := a.authData(false)
+	clientData := a.clientData(protocol.AssertCeremony, options.Response.Challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	r, ss, err := ecdsa.Sign(rand.Reader, a.key, digest[:])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature, _ := asn1.Marshal(struct{ R, S *big.Int }{r, ss})
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":    base64.RawURLEncoding.EncodeToString(a.id),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"signature":         base64.RawURLEncoding.EncodeToString(signature),
+		},
+	})
+
+	return body
+}
+
+// get answers navigator.credentials.get().
+func (a *softAuthenticator) get(t *testing.T, options *protocol.CredentialAssertion) *protocol.ParsedCredentialAssertionData {
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(a.assert(t, options)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return parsed
+}
+
+/**
+Minimal CBOR encoding, for the attestation object and the COSE key
+*/
+
+func cborHead(major byte, n uint64) []byte {
+
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n < 1<<8:
+		return []byte{major<<5 | 24, byte(n)}
+	default:
+		return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+	}
+}
+
+func cborInt(n int) []byte {
+
+	if n < 0 {
+		return cborHead(1, uint64(-1-n))
+	}
+
+	return cborHead(0, uint64(n))
+}
+
+func cborBytes(b []byte) []byte {
+	return append(cborHead(2, uint64(len(b))), b...)
+}
+
+func cborText(s string) []byte {
+	return append(cborHead(3, uint64(len(s))), s...)
+}
+
+func cborMap(pairs ...[]byte) []byte {
+
+	out := cborHead(5, uint64(len(pairs)/2))
+
+	for _, p := range pairs {
+		out = append(out, p...)
+	}
+
+	return out
+}
+
+/**
+Tests
+*/
+
+func newWebAuthnHandler(t *testing.T) *AuthenticateHandler {
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPDisplayName: "test",
+		RPID:          testRPID,
+		RPOrigin:      testRPOrigin,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &AuthenticateHandler{WebAuthn: w, Ceremonies: NewMemoryCeremonyStore()}
+}
+
+// register registers the credential of the authenticator for the user.
+func register(t *testing.T, s *AuthenticateHandler, u *webauthnUser, a *softAuthenticator) {
+
+	options, err := s.beginRegistration(context.Background(), *u)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := s.finishRegistration(context.Background(), *u, a.create(t, options))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u.credentials = append(u.credentials, *credential)
+}
+
+// login runs a login ceremony of the user with the authenticator.
+func login(t *testing.T, s *AuthenticateHandler, u webauthnUser, a *softAuthenticator) (*webauthn.Credential, error) {
+
+	options, err := s.beginLogin(context.Background(), u)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed := a.get(t, options)
+
+	c, err := s.Ceremonies.Take(context.Background(), ceremonyLogin, parsed.Response.CollectedClientData.Challenge)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s.validateLogin(u, c, parsed)
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+
+	s := newWebAuthnHandler(t)
+	a := newSoftAuthenticator(t)
+	u := webauthnUser{User: User{Name: "alice"}}
+
+	options, err := s.beginRegistration(context.Background(), u)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed := a.create(t, options)
+
+	credential, err := s.finishRegistration(context.Background(), u, parsed)
+
+	if err != nil {
+		t.Fatalf("registration failed: %v", err)
+	}
+
+	if !bytes.Equal(credential.ID, a.id) {
+		t.Errorf("credential id = %x, want %x", credential.ID, a.id)
+	}
+
+	// the ceremony is taken, the response cannot be replayed
+
+	if _, err := s.finishRegistration(context.Background(), u, parsed); err != ErrCeremonyNotFound {
+		t.Errorf("replayed registration: err = %v, want %v", err, ErrCeremonyNotFound)
+	}
+
+	// registered credentials are excluded from the next registration
+
+	u.credentials = append(u.credentials, *credential)
+
+	options, err = s.beginRegistration(context.Background(), u)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(options.Response.CredentialExcludeList) != 1 {
+		t.Errorf("excluded credentials = %v, want 1", len(options.Response.CredentialExcludeList))
+	}
+}
+
+func TestWebAuthnRegistrationOfAnotherUser(t *testing.T) {
+
+	s := newWebAuthnHandler(t)
+	a := newSoftAuthenticator(t)
+
+	options, err := s.beginRegistration(context.Background(), webauthnUser{User: User{Name: "alice"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.finishRegistration(context.Background(), webauthnUser{User: User{Name: "mallory"}}, a.create(t, options))
+
+	if err != ErrCeremonyNotFound {
+		t.Errorf("err = %v, want %v", err, ErrCeremonyNotFound)
+	}
+}
+
+func TestWebAuthnRegistrationWrongOrigin(t *testing.T) {
+
+	s := newWebAuthnHandler(t)
+	a := newSoftAuthenticator(t)
+	u := webauthnUser{User: User{Name: "alice"}}
+
+	a.origin = "https://evil.example"
+
+	options, err := s.beginRegistration(context.Background(), u)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.finishRegistration(context.Background(), u, a.create(t, options)); !errors.Is(err, errUnverified) {
+		t.Errorf("err = %v, want %v", err, errUnverified)
+	}
+}
+
+func TestWebAuthnLogin(t *testing.T) {
+
+	s := newWebAuthnHandler(t)
+	a := newSoftAuthenticator(t)
+	u := webauthnUser{User: User{Name: "alice"}}
+
+	register(t, s, &u, a)
+
+	options, err := s.beginLogin(context.Background(), u)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed := a.get(t, options)
+
+	c, err := s.Ceremonies.Take(context.Background(), ceremonyLogin, parsed.Response.CollectedClientData.Challenge)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.User != u.Name {
+		t.Errorf("ceremony user = %v, want %v", c.User, u.Name)
+	}
+
+	credential, err := s.validateLogin(u, c, parsed)
+
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	if credential.Authenticator.SignCount != a.count {
+		t.Errorf("sign count = %v, want %v", credential.Authenticator.SignCount, a.count)
+	}
+
+	// the ceremony is taken, the assertion cannot be replayed
+
+	if _, err := s.Ceremonies.Take(context.Background(), ceremonyLogin, parsed.Response.CollectedClientData.Challenge); err != ErrCeremonyNotFound {
+		t.Errorf("replayed login: err = %v, want %v", err, ErrCeremonyNotFound)
+	}
+}
+
+func TestWebAuthnLoginUnknownCredential(t *testing.T) {
+
+	s := newWebAuthnHandler(t)
+	u := webauthnUser{User: User{Name: "alice"}}
+
+	register(t, s, &u, newSoftAuthenticator(t))
+
+	if _, err := login(t, s, u, newSoftAuthenticator(t)); !errors.Is(err, errUnverified) {
+		t.Errorf("err = %v, want %v", err, errUnverified)
+	}
+}
+
+func TestWebAuthnCloneWarning(t *testing.T) {
+
+	s := newWebAuthnHandler(t)
+	a := newSoftAuthenticator(t)
+	u := webauthnUser{User: User{Name: "alice"}}
+
+	register(t, s, &u, a)
+
+	credential, err := login(t, s, u, a)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u.credentials[0].Authenticator.SignCount = credential.Authenticator.SignCount
+
+	// a copy of the authenticator, behind on the signature counter
+
+	a.count = 0
+
+	if _, err := login(t, s, u, a); err != errCloned {
+		t.Errorf("err = %v, want %v", err, errCloned)
+	}
+}
+
+func TestWebAuthnCeremonyExpiry(t *testing.T) {
+
+	s := newWebAuthnHandler(t)
+	ctx := context.Background()
+
+	c := Ceremony{Challenge: "challenge", User: "alice", Kind: ceremonyLogin, Expires: time.Now().Add(10 * time.Millisecond)}
+
+	if err := s.Ceremonies.Save(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	// a ceremony is only taken by its kind
+
+	if _, err := s.Ceremonies.Take(ctx, ceremonyRegister, c.Challenge); err != ErrCeremonyNotFound {
+		t.Errorf("other kind: err = %v, want %v", err, ErrCeremonyNotFound)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := s.Ceremonies.Take(ctx, ceremonyLogin, c.Challenge); err != ErrCeremonyNotFound {
+		t.Errorf("expired: err = %v, want %v", err, ErrCeremonyNotFound)
+	}
+
+	// expired ceremonies are not saved
+
+	c.Expires = time.Now().Add(-time.Second)
+
+	if err := s.Ceremonies.Save(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Ceremonies.Take(ctx, ceremonyLogin, c.Challenge); err != ErrCeremonyNotFound {
+		t.Errorf("saved expired: err = %v, want %v", err, ErrCeremonyNotFound)
+	}
+}
+
+// mockDocument converts a document to a mock response of the database.
+func mockDocument(t *testing.T, document interface{}) bson.D {
+
+	data, err := bson.Marshal(document)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d bson.D
+
+	if err := bson.Unmarshal(data, &d); err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+// mockLoadUser queues the responses of loadWebAuthnUser.
+func mockLoadUser(mt *mtest.T, u webauthnUser) {
+
+	user := mtest.CreateCursorResponse(0, "test."+userCollection, mtest.FirstBatch, mockDocument(mt.T, u.User))
+
+	var passkeys []bson.D
+
+	for _, c := range u.credentials {
+		passkeys = append(passkeys, mockDocument(mt.T, Passkey{
+			ID:         base64.RawURLEncoding.EncodeToString(c.ID),
+			User:       u.Name,
+			Credential: c,
+		}))
+	}
+
+	mt.AddMockResponses(user, mtest.CreateCursorResponse(0, "test."+credentialCollection, mtest.FirstBatch, passkeys...))
+}
+
+func TestWebAuthnLoginHandlers(t *testing.T) {
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("repeated logins", func(mt *mtest.T) {
+
+		s := newWebAuthnHandler(mt.T)
+		s.DB = mt.DB
+		s.Sessions = NewMemoryStore()
+		s.Keys = testKeySet(mt.T, time.Now())
+
+		store := NewMemoryThrottleStore()
+		s.Throttle = NewThrottle(store)
+
+		a := newSoftAuthenticator(mt.T)
+		u := webauthnUser{User: User{Name: "alice", Role: RoleEditor}}
+
+		register(mt.T, s, &u, a)
+
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		s.registerWebAuthnRoutes(r)
+
+		post := func(path string, body []byte) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(w, req)
+			return w
+		}
+
+		// more logins than the free attempts of a client: none of them is counted as a failure
+
+		for i := 0; i < 2*throttleFreeAttempts; i++ {
+
+			mockLoadUser(mt, u)
+
+			w := post("/auth/webauthn/login/begin", []byte(`{"user":"alice"}`))
+
+			if w.Code != http.StatusOK {
+				mt.Fatalf("login %v: begin: status = %v, want %v", i, w.Code, http.StatusOK)
+			}
+
+			var options protocol.CredentialAssertion
+
+			if err := json.Unmarshal(w.Body.Bytes(), &options); err != nil {
+				mt.Fatal(err)
+			}
+
+			// the credential is updated with the signature counter
+
+			mockLoadUser(mt, u)
+			mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+			w = post("/auth/webauthn/login/finish", a.assert(mt.T, &options))
+
+			if w.Code != http.StatusOK {
+				mt.Fatalf("login %v: finish: status = %v, want %v", i, w.Code, http.StatusOK)
+			}
+		}
+
+		for _, key := range []string{throttleClientPrefix + "192.0.2.1", throttleUserPrefix + u.Name, throttleGlobalKey} {
+
+			val, _ := store.Cache.Get(key)
+
+			if val.(attempts).Failures != 0 {
+				mt.Errorf("%v: failures = %v, want 0", key, val.(attempts).Failures)
+			}
+		}
+	})
+}
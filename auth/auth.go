@@ -1,13 +1,75 @@
 package auth
 
 import (
-	"github.com/patrickmn/go-cache"
+	"time"
+
+	"github.com/duo-labs/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// otpSecretPath is the legacy single-secret file, migrated into the users collection on initialisation.
 const otpSecretPath = ".otp"
 
+const userCollection = "users"
+
+// defaultAdmin is the account name of the bootstrapped administrator.
+const defaultAdmin = "admin"
+
 // AuthenticateHandler is a helper struct for all page handlers.
 type AuthenticateHandler struct {
-	Issuer string
-	Cache  *cache.Cache
+	Issuer     string
+	Sessions   SessionStore
+	Throttle   *Throttle
+	Ceremonies CeremonyStore // webauthn ceremonies, required if WebAuthn is set
+	Keys       *KeySet
+	WebAuthn   *webauthn.WebAuthn // optional, webauthn routes are only registered if set
+	Peers      *PeerPolicy        // optional, unix socket peers are only trusted if set
+	Audit      Recorder           // optional, changes to users and tokens are only recorded if set
+	DB         *mongo.Database
+}
+
+// Role denotes the privileges of a user.
+type Role string
+
+const (
+	// RoleAdmin can manage users, collections and content.
+	RoleAdmin Role = "admin"
+	// RoleEditor can create, edit and delete content.
+	RoleEditor Role = "editor"
+	// RoleViewer can read drafts, but not modify anything.
+	RoleViewer Role = "viewer"
+)
+
+// User is an account that can authenticate against the api.
+type User struct {
+	// Name is the unique account name.
+	Name string `json:"_id" bson:"_id" binding:"required"`
+
+	// Role is the privilege level of the user.
+	Role Role `json:"role" bson:"role" binding:"required,oneof=admin editor viewer"`
+
+	// Secret is the TOTP secret of the user. It is never serialised to json.
+	Secret string `json:"-" bson:"secret"`
+
+	// RecoveryCodes are the hashes of the unused one-time recovery codes of the user.
+	RecoveryCodes []string `json:"-" bson:"recovery_codes"`
+
+	// Created is a timestamp indicating when the user was enrolled.
+	Created time.Time `json:"created" bson:"created"`
+}
+
+// Identity is the authenticated user (or service token) bound to a request, readable with GetIdentity.
+type Identity struct {
+	User   string   `json:"user" bson:"user"`
+	Role   Role     `json:"role,omitempty" bson:"role,omitempty"`
+	Scopes []string `json:"scopes" bson:"scopes"`
+}
+
+// identity returns the identity of the user, with the scopes granted by their role.
+func (u User) identity() Identity {
+	return Identity{
+		User:   u.Name,
+		Role:   u.Role,
+		Scopes: roleScopes[u.Role],
+	}
 }
@@ -0,0 +1,917 @@
+package coll
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/lexffe/backend.lexffe.io/audit"
+	"github.com/lexffe/backend.lexffe.io/auth"
+	"github.com/lexffe/backend.lexffe.io/handlers"
+	"github.com/lexffe/backend.lexffe.io/helpers"
+	"github.com/lexffe/backend.lexffe.io/models"
+	"github.com/lexffe/backend.lexffe.io/storage"
+	"github.com/xeipuuv/gojsonschema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/**
+Archives of collections are tar.gz files, with the entries in this order:
+- meta.json: the meta document of the collection
+- files/<sha256>: the files of an asset collection
+- documents.jsonl: the documents, one per line, in canonical extended JSON
+*/
+
+const (
+	archiveMeta      = "meta.json"
+	archiveFiles     = "files/"
+	archiveDocuments = "documents.jsonl"
+)
+
+// maxLineSize is the maximum size of a document in documents.jsonl. Extended JSON is larger than BSON, whose
+// documents are at most 16MB.
+const maxLineSize = 64 << 20
+
+// importSummary is the outcome of an import.
+type importSummary struct {
+	Collection string `json:"collection" bson:"collection"`
+
+	// Created is true if the collection did not exist before the import.
+	Created bool `json:"created" bson:"created"`
+
+	// Inserted, Overwritten and Renamed are the documents written, Skipped and Invalid the documents not written.
+	Inserted    int `json:"inserted" bson:"inserted"`
+	Overwritten int `json:"overwritten" bson:"overwritten"`
+	Renamed     int `json:"renamed" bson:"renamed"`
+	Skipped     int `json:"skipped" bson:"skipped"`
+	Invalid     int `json:"invalid" bson:"invalid"`
+
+	// Files is the number of files of assets written to the store.
+	Files int `json:"files" bson:"files"`
+}
+
+// exportHandler responds with an archive of a collection.
+func (c *CollectionDelegate) exportHandler(ctx *gin.Context) {
+
+	helpers.SetDeadline(ctx.Request.Context(), helpers.StreamDeadline)
+
+	res := c.DB.Collection(metaCollection).FindOne(ctx.Request.Context(), bson.M{"_id": ctx.Param("name")})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.Status(http.StatusInternalServerError)
+			ctx.Error(res.Err())
+		}
+		return
+	}
+
+	var meta MetaCollectionModel
+
+	if err := res.Decode(&meta); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// the documents are written to a temporary file first, the size of a tar entry is needed before its content.
+
+	tmp, err := ioutil.TempFile("", "export-")
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot create file"))
+		ctx.Error(err)
+		return
+	}
+
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	assets, err := c.spoolDocuments(ctx.Request.Context(), meta, tmp)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot export documents"))
+		ctx.Error(err)
+		return
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot export documents"))
+		ctx.Error(err)
+		return
+	}
+
+	// the archive is written completely before the response, so that a failure is not sent as a truncated archive
+
+	archive, err := ioutil.TempFile("", "export-")
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot create file"))
+		ctx.Error(err)
+		return
+	}
+
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	if err := c.writeArchive(ctx.Request.Context(), archive, meta, assets, tmp); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot export collection"))
+		ctx.Error(err)
+		return
+	}
+
+	size, err := archive.Seek(0, io.SeekCurrent)
+
+	if err == nil {
+		_, err = archive.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot export collection"))
+		ctx.Error(err)
+		return
+	}
+
+	filename := fmt.Sprintf("%v-%v.tar.gz", meta.Name, time.Now().UTC().Format("20060102"))
+
+	ctx.DataFromReader(http.StatusOK, size, "application/gzip", archive, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%q", filename),
+	})
+}
+
+// spoolDocuments writes the documents of the collection to w, one per line, and returns the assets of an asset
+// collection.
+func (c *CollectionDelegate) spoolDocuments(ctx context.Context, meta MetaCollectionModel, w io.Writer) ([]models.Asset, error) {
+
+	cur, err := c.DB.Collection(meta.Name).Find(ctx, bson.M{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer cur.Close(ctx)
+
+	var assets []models.Asset
+
+	for cur.Next(ctx) {
+
+		line, err := bson.MarshalExtJSON(cur.Current, true, false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return nil, err
+		}
+
+		if meta.Type != models.TypeAsset {
+			continue
+		}
+
+		var asset models.Asset
+
+		if err := cur.Decode(&asset); err != nil {
+			return nil, err
+		}
+
+		assets = append(assets, asset)
+	}
+
+	return assets, cur.Err()
+}
+
+// writeArchive writes the archive of a collection to w.
+func (c *CollectionDelegate) writeArchive(ctx context.Context, w io.Writer, meta MetaCollectionModel, assets []models.Asset, documents *os.File) error {
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	now := time.Now()
+
+	// meta.json
+
+	m, err := json.Marshal(meta)
+
+	if err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Name: archiveMeta, Mode: 0644, Size: int64(len(m)), ModTime: now}); err != nil {
+		return err
+	}
+
+	if _, err := tw.Write(m); err != nil {
+		return err
+	}
+
+	// files/<sha256>
+
+	for _, asset := range assets {
+
+		store, ok := c.Stores[asset.Storage]
+
+		if !ok {
+			return errors.New("storage backend is not configured: " + asset.Storage)
+		}
+
+		f, err := store.Open(ctx, asset.Key)
+
+		if err != nil {
+			return err
+		}
+
+		err = tw.WriteHeader(&tar.Header{Name: archiveFiles + asset.Hash, Mode: 0644, Size: asset.Size, ModTime: asset.Created})
+
+		if err == nil {
+			_, err = io.CopyN(tw, f, asset.Size)
+		}
+
+		f.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// documents.jsonl
+
+	info, err := documents.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Name: archiveDocuments, Mode: 0644, Size: info.Size(), ModTime: now}); err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(tw, documents, info.Size()); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// importHandler restores an archive into a new or existing collection.
+func (c *CollectionDelegate) importHandler(ctx *gin.Context) {
+
+	helpers.SetDeadline(ctx.Request.Context(), helpers.StreamDeadline)
+
+	// what happens to documents in conflict with existing ones: skipped (default), overwritten, or inserted with a
+	// new identifier
+
+	conflict := ctx.DefaultQuery("conflict", "skip")
+
+	if conflict != "skip" && conflict != "overwrite" && conflict != "rename" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("conflict should be skip, overwrite or rename"))
+		return
+	}
+
+	header, err := ctx.FormFile("archive")
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("no archive provided"))
+		ctx.Error(err)
+		return
+	}
+
+	file, err := header.Open()
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot read archive"))
+		ctx.Error(err)
+		return
+	}
+
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed archive"))
+		ctx.Error(err)
+		return
+	}
+
+	tr := tar.NewReader(gz)
+
+	// meta.json comes first
+
+	hdr, err := tr.Next()
+
+	if err != nil || hdr.Name != archiveMeta {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed archive, expected "+archiveMeta+" first"))
+		if err != nil {
+			ctx.Error(err)
+		}
+		return
+	}
+
+	var source MetaCollectionModel
+
+	if err := json.NewDecoder(tr).Decode(&source); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed archive, cannot parse "+archiveMeta))
+		ctx.Error(err)
+		return
+	}
+
+	// the collection is restored under its own name, or another one
+
+	target, created, ok := c.importTarget(ctx, source, ctx.DefaultQuery("name", source.Name))
+
+	if !ok {
+		return
+	}
+
+	summary := importSummary{Collection: target.Name, Created: created}
+
+	// imported pages are saved as revisions of the importing user
+
+	var author string
+
+	if id, ok := auth.GetIdentity(ctx); ok {
+		author = id.User
+	}
+
+	var store storage.Store
+	var schema *gojsonschema.Schema
+
+	switch target.Type {
+
+	case models.TypeAsset:
+		target.Storage = c.assetHandler(target).Storage
+		store = c.Stores[target.Storage]
+
+		if store == nil {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("storage backend is not configured: "+target.Storage))
+			return
+		}
+
+	case models.TypeCustom:
+		schema, err = handlers.CompileSchema(target.Schema)
+
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot compile schema of collection"))
+			ctx.Error(err)
+			return
+		}
+	}
+
+	// files/<sha256>, then documents.jsonl
+
+	files := map[string]bool{}
+	done := false
+
+	for {
+		hdr, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err == nil && done {
+			err = errors.New("entry after " + archiveDocuments)
+		}
+
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed archive"))
+			ctx.Error(err)
+			return
+		}
+
+		switch {
+
+		case strings.HasPrefix(hdr.Name, archiveFiles) && store != nil:
+			hash := strings.TrimPrefix(hdr.Name, archiveFiles)
+
+			if err := importFile(ctx.Request.Context(), store, target.prefix()+"/"+hash, hash, tr, hdr.Size); err != nil {
+				ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed archive, cannot import "+hdr.Name))
+				ctx.Error(err)
+				return
+			}
+
+			files[hash] = true
+			summary.Files++
+
+		case hdr.Name == archiveDocuments:
+			if err := c.importDocuments(ctx.Request.Context(), target, schema, files, conflict, author, tr, &summary); err != nil {
+				ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed archive, cannot import "+archiveDocuments))
+				ctx.Error(err)
+				return
+			}
+
+			done = true
+
+		default:
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed archive, unexpected entry "+hdr.Name))
+			return
+		}
+	}
+
+	c.Audit.Record(ctx, audit.ActionImport, target.Name, nil, nil, summary)
+
+	ctx.JSON(http.StatusOK, summary)
+}
+
+// importTarget returns the meta of the collection an archive is restored into, creating and mounting the
+// collection if it does not exist. It responds with an error if the archive cannot be restored into it.
+func (c *CollectionDelegate) importTarget(ctx *gin.Context, source MetaCollectionModel, name string) (MetaCollectionModel, bool, bool) {
+
+	res := c.DB.Collection(metaCollection).FindOne(ctx.Request.Context(), bson.M{"_id": name})
+
+	// existing collection: the documents have to be of the same type
+
+	if res.Err() == nil {
+
+		var target MetaCollectionModel
+
+		if err := res.Decode(&target); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return target, false, false
+		}
+
+		if target.Type != source.Type {
+			ctx.AbortWithError(http.StatusConflict, errors.New("collection exists with another type"))
+			return target, false, false
+		}
+
+		return target, false, true
+	}
+
+	if res.Err() != mongo.ErrNoDocuments {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot find collection"))
+		ctx.Error(res.Err())
+		return source, false, false
+	}
+
+	// new collection: same checks as createCollHandler
+
+	target := source
+	target.Name = name
+	target.Aliases = nil
+	target.Prefix = ""
+
+	if target.Type == models.TypeAsset {
+		target.Prefix = newPrefix(target.Name)
+	}
+
+	if !validName(target.Name) {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid collection name"))
+		return target, false, false
+	}
+
+	if reserved(target.Name) {
+		ctx.AbortWithError(http.StatusConflict, errors.New("collection name is in conflict with the router's internal routes"))
+		return target, false, false
+	}
+
+	if !validType(target.Type) {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("collection type is not implemented"))
+		return target, false, false
+	}
+
+	if err := c.checkStorage(target); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return target, false, false
+	}
+
+	if err := checkSchema(target); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return target, false, false
+	}
+
+	if err := target.settings().Validate(); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return target, false, false
+	}
+
+	taken, err := c.nameTaken(ctx.Request.Context(), target.Name, "")
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot check conflict"))
+		ctx.Error(err)
+		return target, false, false
+	}
+
+	if taken {
+		ctx.AbortWithError(http.StatusConflict, errors.New("collection name is in conflict existing collection(s)"))
+		return target, false, false
+	}
+
+	if _, err := c.DB.Collection(metaCollection).InsertOne(ctx.Request.Context(), target); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot insert document"))
+		ctx.Error(err)
+		return target, false, false
+	}
+
+	// mounted before the documents are written, so that the unique indexes exist
+
+	if err := c.mount(ctx.Request.Context(), target); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot register collection"))
+		ctx.Error(err)
+		return target, false, false
+	}
+
+	c.Audit.Record(ctx, audit.ActionCreate, target.Name, nil, nil, target)
+
+	return target, true, true
+}
+
+// importFile writes a file of an archive to the store, checking its content against the hash first. Existing files
+// are kept, their key is the hash of their content.
+func importFile(ctx context.Context, store storage.Store, key string, hash string, r io.Reader, size int64) error {
+
+	if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+		return errors.New("invalid file name")
+	}
+
+	if f, err := store.Open(ctx, key); err == nil {
+		return f.Close()
+	} else if err != storage.ErrNotFound {
+		return err
+	}
+
+	// the content is only written to the key once it matches the hash
+
+	tmp, err := ioutil.TempFile("", "import-")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	sum := sha256.New()
+
+	if _, err := io.CopyN(tmp, io.TeeReader(r, sum), size); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(sum.Sum(nil)) != hash {
+		return errors.New("content does not match hash")
+	}
+
+	head := make([]byte, helpers.SniffLen)
+
+	n, err := tmp.ReadAt(head, 0)
+
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return store.Put(ctx, key, tmp, size, http.DetectContentType(head[:n]))
+}
+
+// importDocuments writes the documents of an archive to the collection.
+func (c *CollectionDelegate) importDocuments(ctx context.Context, target MetaCollectionModel, schema *gojsonschema.Schema, files map[string]bool, conflict string, author string, r io.Reader, summary *importSummary) error {
+
+	coll := c.DB.Collection(target.Name)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+
+	for scanner.Scan() {
+
+		line := scanner.Bytes()
+
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var doc bson.M
+
+		if err := bson.UnmarshalExtJSON(line, true, &doc); err != nil {
+			return err
+		}
+
+		doc, ok := importable(target, schema, files, doc)
+
+		if !ok {
+			summary.Invalid++
+			continue
+		}
+
+		_, err := coll.InsertOne(ctx, doc)
+
+		if err == nil {
+			summary.Inserted++
+
+			if err := c.saveImportRevisions(target, nil, doc, author); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if !helpers.IsDuplicateKey(err) {
+			return err
+		}
+
+		switch conflict {
+
+		case "skip":
+			summary.Skipped++
+
+		case "overwrite":
+			// only a document with the same identifier is replaced, other conflicts (e.g. titles) are skipped
+
+			filter := bson.M{"_id": doc["_id"]}
+
+			// pages and references continue from the version of the existing document, which is part of the filter
+
+			var previous *models.Page
+
+			if target.Type == models.TypePage || target.Type == models.TypeRef {
+
+				var existing bson.Raw
+
+				err := coll.FindOne(ctx, filter).Decode(&existing)
+
+				if err == mongo.ErrNoDocuments {
+					summary.Skipped++
+					continue
+				}
+
+				if err != nil {
+					return err
+				}
+
+				var current struct {
+					Version int64 `bson:"version"`
+				}
+
+				if err := bson.Unmarshal(existing, &current); err != nil {
+					return err
+				}
+
+				// the overwritten page is its first revision, if it has none
+
+				if target.Type == models.TypePage {
+
+					previous = &models.Page{}
+
+					if err := bson.Unmarshal(existing, previous); err != nil {
+						return err
+					}
+				}
+
+				filter["version"] = handlers.VersionFilter(current.Version)
+				doc["version"] = current.Version + 1
+			}
+
+			res, err := coll.ReplaceOne(ctx, filter, doc)
+
+			if err != nil && !helpers.IsDuplicateKey(err) {
+				return err
+			}
+
+			if err != nil || res.MatchedCount == 0 {
+				summary.Skipped++
+				continue
+			}
+
+			summary.Overwritten++
+
+			if err := c.saveImportRevisions(target, previous, doc, author); err != nil {
+				return err
+			}
+
+		case "rename":
+			// assets with the same content cannot be renamed, the hash is unique
+
+			objID := primitive.NewObjectID()
+			doc["_id"] = objID
+
+			if title, ok := doc["searchable_title"].(string); ok && target.Type == models.TypePage {
+				doc["searchable_title"] = title + "-" + objID.Hex()[18:]
+
+				if _, ok := doc["slug"]; ok {
+					doc["slug"] = doc["searchable_title"]
+				}
+			}
+
+			_, err := coll.InsertOne(ctx, doc)
+
+			if err != nil && !helpers.IsDuplicateKey(err) {
+				return err
+			}
+
+			if err != nil {
+				summary.Skipped++
+				continue
+			}
+
+			summary.Renamed++
+
+			if err := c.saveImportRevisions(target, nil, doc, author); err != nil {
+				return err
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// saveImportRevisions saves an imported page as a revision, and the page it overwrote (if any) first if the page
+// has no revisions yet. Other documents have no revisions.
+func (c *CollectionDelegate) saveImportRevisions(target MetaCollectionModel, previous *models.Page, doc bson.M, author string) error {
+
+	if target.Type != models.TypePage {
+		return nil
+	}
+
+	raw, err := bson.Marshal(doc)
+
+	if err != nil {
+		return err
+	}
+
+	var page models.Page
+
+	if err := bson.Unmarshal(raw, &page); err != nil {
+		return err
+	}
+
+	return handlers.SaveRevisions(c.DB, target.Name, previous, page, author)
+}
+
+// importable returns the document of an archive as written to the collection, and reports whether it can be
+// written. The file of an asset is moved to the store of the collection, the generated fields of a page are
+// generated again.
+func importable(target MetaCollectionModel, schema *gojsonschema.Schema, files map[string]bool, doc bson.M) (bson.M, bool) {
+
+	if _, ok := doc["_id"]; !ok {
+		return doc, false
+	}
+
+	switch target.Type {
+
+	case models.TypePage:
+		return importPage(target, doc)
+
+	case models.TypeRef:
+		return importReference(target, doc)
+
+	case models.TypeAsset:
+		hash, _ := doc["hash"].(string)
+
+		if !files[hash] {
+			return doc, false
+		}
+
+		doc["storage"] = target.Storage
+		doc["key"] = target.prefix() + "/" + hash
+
+		// derivatives are generated again on request
+		delete(doc, "derivatives")
+
+	case models.TypeCustom:
+		if _, ok := helpers.OperatorField(doc); ok {
+			return doc, false
+		}
+
+		// the schema validates the document as written by the api, without the identifier
+
+		body := bson.M{}
+
+		for k, v := range doc {
+			if k != "_id" {
+				body[k] = v
+			}
+		}
+
+		res, err := schema.Validate(gojsonschema.NewGoLoader(map[string]interface{}(body)))
+
+		if err != nil || !res.Valid() {
+			return doc, false
+		}
+	}
+
+	return doc, true
+}
+
+// importReference checks a reference of an archive as createReferenceHandler does, and generates its type and
+// version again.
+func importReference(target MetaCollectionModel, doc bson.M) (bson.M, bool) {
+
+	if _, ok := helpers.OperatorField(doc); ok {
+		return doc, false
+	}
+
+	raw, err := bson.Marshal(doc)
+
+	if err != nil {
+		return doc, false
+	}
+
+	var ref models.Reference
+
+	if err := bson.Unmarshal(raw, &ref); err != nil {
+		return doc, false
+	}
+
+	if err := binding.Validator.ValidateStruct(ref); err != nil {
+		return doc, false
+	}
+
+	ref.ReferenceType = target.Type
+	ref.Version = 1
+
+	if raw, err = bson.Marshal(ref); err != nil {
+		return doc, false
+	}
+
+	var generated bson.M
+
+	if err := bson.Unmarshal(raw, &generated); err != nil {
+		return doc, false
+	}
+
+	return generated, true
+}
+
+// importPage generates the html, searchable title and version of a page of an archive again, as createPageHandler
+// does: the html of an archive is not trusted, it is rendered with the markdown profile of the collection.
+func importPage(target MetaCollectionModel, doc bson.M) (bson.M, bool) {
+
+	raw, err := bson.Marshal(doc)
+
+	if err != nil {
+		return doc, false
+	}
+
+	var page models.Page
+
+	if err := bson.Unmarshal(raw, &page); err != nil {
+		return doc, false
+	}
+
+	if page.Title == "" || page.Subtitle == "" || page.Markdown == "" || page.Tags == nil {
+		return doc, false
+	}
+
+	if page.CheckSchedule() != nil || page.CheckSlug() != nil {
+		return doc, false
+	}
+
+	if page.SearchableTitle, err = handlers.SearchableTitle(page); err != nil {
+		return doc, false
+	}
+
+	if page.HTML, err = helpers.ParseMDProfile(page.Markdown, target.settings().Markdown); err != nil {
+		return doc, false
+	}
+
+	// the current slug is not a previous one
+
+	var previous []string
+
+	for _, slug := range page.PreviousSlugs {
+		if slug != page.SearchableTitle {
+			previous = append(previous, slug)
+		}
+	}
+
+	page.PreviousSlugs = previous
+	page.PageType = target.Type
+	page.Version = 1
+
+	if raw, err = bson.Marshal(page); err != nil {
+		return doc, false
+	}
+
+	var generated bson.M
+
+	if err := bson.Unmarshal(raw, &generated); err != nil {
+		return doc, false
+	}
+
+	return generated, true
+}
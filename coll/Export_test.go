@@ -0,0 +1,141 @@
+package coll
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/lexffe/backend.lexffe.io/handlers"
+	"github.com/lexffe/backend.lexffe.io/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestImportReference(t *testing.T) {
+
+	target := MetaCollectionModel{Name: "links", Type: models.TypeRef}
+
+	reference := func() bson.M {
+		return bson.M{
+			"_id":              primitive.NewObjectID(),
+			"name":             "name",
+			"description":      "description",
+			"reference_source": "source",
+			"reference_type":   "page",
+			"version":          int64(7),
+		}
+	}
+
+	doc, ok := importable(target, nil, nil, reference())
+
+	if !ok {
+		t.Fatalf("valid reference is not importable")
+	}
+
+	if doc["reference_type"] != string(models.TypeRef) || doc["version"] != int64(1) {
+		t.Errorf("generated fields = %v, %v, want %v, 1", doc["reference_type"], doc["version"], models.TypeRef)
+	}
+
+	// the same checks as createReferenceHandler
+
+	missing := reference()
+	delete(missing, "description")
+
+	operator := reference()
+	operator["$where"] = "sleep(1000)"
+
+	nested := reference()
+	nested["url"] = bson.M{"$gt": ""}
+
+	for name, doc := range map[string]bson.M{"missing field": missing, "operator": operator, "nested operator": nested} {
+		if _, ok := importable(target, nil, nil, doc); ok {
+			t.Errorf("%v: reference is importable", name)
+		}
+	}
+}
+
+func TestImportOverwrite(t *testing.T) {
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("page", func(mt *mtest.T) {
+
+		c := &CollectionDelegate{DB: mt.DB}
+		target := MetaCollectionModel{Name: "posts", Type: models.TypePage}
+
+		objID := primitive.NewObjectID()
+
+		line, err := bson.MarshalExtJSON(bson.M{
+			"_id":      objID,
+			"title":    "title",
+			"subtitle": "subtitle",
+			"markdown": "markdown",
+			"tags":     bson.A{"tag"},
+			"version":  int64(1),
+		}, true, false)
+
+		if err != nil {
+			mt.Fatal(err)
+		}
+
+		// the existing page has an int32 version, and no revisions
+
+		existing := bson.D{
+			{Key: "_id", Value: objID},
+			{Key: "title", Value: "old title"},
+			{Key: "version", Value: int32(3)},
+		}
+
+		revisions := "test." + handlers.RevisionCollection
+
+		mt.AddMockResponses(
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 11000, Message: "duplicate key"}),
+			mtest.CreateCursorResponse(0, "test.posts", mtest.FirstBatch, existing),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateCursorResponse(0, revisions, mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, revisions, mtest.FirstBatch),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateCursorResponse(0, revisions, mtest.FirstBatch, bson.D{{Key: "rev", Value: 1}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+
+		var summary importSummary
+
+		if err := c.importDocuments(context.Background(), target, nil, nil, "overwrite", "alice", strings.NewReader(string(line)+"\n"), &summary); err != nil {
+			mt.Fatal(err)
+		}
+
+		if summary.Overwritten != 1 {
+			mt.Fatalf("summary = %+v, want 1 overwritten", summary)
+		}
+
+		var revisionVersions []int64
+
+		for evt := mt.GetStartedEvent(); evt != nil; evt = mt.GetStartedEvent() {
+
+			switch {
+
+			case evt.CommandName == "update":
+				if v := evt.Command.Lookup("updates", "0", "q", "version").Int64(); v != 3 {
+					mt.Errorf("filter version = %v, want 3", v)
+				}
+
+				if v := evt.Command.Lookup("updates", "0", "u", "version").Int64(); v != 4 {
+					mt.Errorf("version = %v, want 4", v)
+				}
+
+			case evt.CommandName == "insert" && evt.Command.Lookup("insert").StringValue() == handlers.RevisionCollection:
+				rev := evt.Command.Lookup("documents", "0").Document()
+				revisionVersions = append(revisionVersions, rev.Lookup("page", "version").Int64())
+			}
+		}
+
+		// the overwritten page first, then the imported one
+
+		if len(revisionVersions) != 2 || revisionVersions[0] != 3 || revisionVersions[1] != 4 {
+			mt.Errorf("revisions of versions %v, want [3 4]", revisionVersions)
+		}
+	})
+}
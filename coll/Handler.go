@@ -1,12 +1,19 @@
 package coll
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"regexp"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/audit"
 	"github.com/lexffe/backend.lexffe.io/auth"
 	"github.com/lexffe/backend.lexffe.io/handlers"
+	"github.com/lexffe/backend.lexffe.io/helpers"
 	"github.com/lexffe/backend.lexffe.io/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -17,12 +24,25 @@ func (c *CollectionDelegate) RegisterRoutes() {
 
 	// all routes should be guarded.
 
-	router := c.Engine.Group("/coll", auth.CheckAuthentication)
+	router := c.Engine.Group("/coll", auth.CheckAuthentication("admin:coll"))
+
+	// collections are served from the registry, by whatever the main engine does not match
+
+	c.collections = newRegistry()
+	c.Engine.NoRoute(c.dispatch)
 
 	router.GET("/", c.getCollsHandler)
 	//router.GET("/:name", c.getCollHandler)
 	router.POST("/", c.createCollHandler)
+	router.PUT("/:name", c.updateCollHandler)
 	router.DELETE("/:name", c.deleteCollHandler)
+	router.GET("/:name/indexes", c.getIndexesHandler)
+	router.GET("/:name/stats", c.getStatsHandler)
+	router.GET("/:name/export", c.exportHandler)
+	router.POST("/import", c.importHandler)
+
+	// the schema of a custom collection is needed by editors, e.g. to build forms
+	c.Engine.GET("/coll/:name/schema", auth.CheckIdentity, c.getSchemaHandler)
 
 }
 
@@ -96,6 +116,78 @@ func (c *CollectionDelegate) getCollsHandler(ctx *gin.Context) {
 //
 //}
 
+// getSchemaHandler returns the JSON Schema of a custom collection.
+func (c *CollectionDelegate) getSchemaHandler(ctx *gin.Context) {
+
+	res := c.DB.Collection(metaCollection).FindOne(ctx.Request.Context(), bson.M{"_id": ctx.Param("name")})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.Status(http.StatusInternalServerError)
+			ctx.Error(res.Err())
+		}
+		return
+	}
+
+	var meta MetaCollectionModel
+
+	if err := res.Decode(&meta); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if meta.Type != models.TypeCustom {
+		ctx.AbortWithError(http.StatusNotFound, errors.New("collection has no schema"))
+		return
+	}
+
+	ctx.Data(http.StatusOK, "application/schema+json", meta.Schema)
+}
+
+// getIndexesHandler lists the indexes of a collection.
+func (c *CollectionDelegate) getIndexesHandler(ctx *gin.Context) {
+
+	collName := ctx.Param("name")
+
+	n, err := c.DB.Collection(metaCollection).CountDocuments(ctx.Request.Context(), bson.M{"_id": collName})
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("meta: error occured at find command"))
+		ctx.Error(err)
+		return
+	}
+
+	if n == 0 {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+
+	cur, err := c.DB.Collection(collName).Indexes().List(ctx.Request.Context())
+
+	if err != nil {
+		// nothing was written to the collection yet
+		if helpers.IsNamespaceNotFound(err) {
+			ctx.JSON(http.StatusOK, []int{})
+			return
+		}
+
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot list indexes"))
+		ctx.Error(err)
+		return
+	}
+
+	results := []bson.M{}
+
+	if err := cur.All(ctx.Request.Context(), &results); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, results)
+}
+
 func (c *CollectionDelegate) createCollHandler(ctx *gin.Context) {
 	var body MetaCollectionModel
 
@@ -105,14 +197,39 @@ func (c *CollectionDelegate) createCollHandler(ctx *gin.Context) {
 		return
 	}
 
-	// prevent route collision with "coll" / "auth"
-	if body.Name == "coll" || body.Name == "auth" {
+	if !validName(body.Name) {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid collection name"))
+		return
+	}
+
+	// prevent route collision with "coll" / "auth" / "users" / "audit", and internal mongo collections
+	if reserved(body.Name) {
 		ctx.AbortWithError(http.StatusConflict, errors.New("collection name is in conflict with the router's internal routes"))
 		return
 	}
 
-	// prevent existing collection collision
-	count, err := c.DB.Collection(metaCollection).CountDocuments(ctx.Request.Context(), bson.M{"name": body.Name})
+	if !validType(body.Type) {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("collection type is not implemented"))
+		return
+	}
+
+	if err := c.checkStorage(body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if err := checkSchema(body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if err := body.settings().Validate(); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	// prevent existing collection collision, including previous names of renamed collections
+	taken, err := c.nameTaken(ctx.Request.Context(), body.Name, "")
 
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot check conflict"))
@@ -120,13 +237,20 @@ func (c *CollectionDelegate) createCollHandler(ctx *gin.Context) {
 		return
 	}
 
-	if count > 0 {
+	if taken {
 		ctx.AbortWithError(http.StatusConflict, errors.New("collection name is in conflict existing collection(s)"))
 		return
 	}
 
 	// actually append and insert
 
+	body.Aliases = nil
+	body.Prefix = ""
+
+	if body.Type == models.TypeAsset {
+		body.Prefix = newPrefix(body.Name)
+	}
+
 	_, err = c.DB.Collection(metaCollection).InsertOne(ctx.Request.Context(), body)
 
 	if err != nil {
@@ -137,37 +261,284 @@ func (c *CollectionDelegate) createCollHandler(ctx *gin.Context) {
 
 	// Live register new routes
 
-	switch body.Type {
+	if err := c.mount(ctx.Request.Context(), body); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot register collection"))
+		ctx.Error(err)
+		return
+	}
+
+	c.Audit.Record(ctx, audit.ActionCreate, body.Name, nil, nil, body)
+
+	ctx.Status(http.StatusCreated)
+}
+
+func (c *CollectionDelegate) updateCollHandler(ctx *gin.Context) {
+
+	collName := ctx.Param("name")
+
+	// parse body
+	// body: { _id, type, aliases, storage, schema, settings }, every field is optional
+
+	var body MetaCollectionModel
+
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed request body"))
+		ctx.Error(err)
+		return
+	}
+
+	res := c.DB.Collection(metaCollection).FindOne(ctx.Request.Context(), bson.M{"_id": collName})
 
-	case models.TypePage:
-		h := handlers.PageHandler{
-			Router:     c.Engine.Group(body.Name),
-			DB:         c.DB,
-			PageType:   body.Type,
-			Collection: body.Name,
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.Status(http.StatusInternalServerError)
 		}
-		h.RegisterRoutes()
+		ctx.Error(res.Err())
+		return
+	}
+
+	var before MetaCollectionModel
 
-	case models.TypeRef:
-		h := handlers.ReferenceHandler{
-			Router:        c.Engine.Group(body.Name),
-			DB:            c.DB,
-			ReferenceType: body.Type,
-			Collection:    body.Name,
+	if err := res.Decode(&before); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	after := before
+
+	// type: only empty collections can change their type, as the documents would not match
+
+	if body.Type != "" && body.Type != before.Type {
+
+		if !validType(body.Type) {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("collection type is not implemented"))
+			return
 		}
-		h.RegisterRoutes()
 
-	default:
+		n, err := c.DB.Collection(collName).CountDocuments(ctx.Request.Context(), bson.M{})
 
-		// should not be reachable. BindJSON should check type
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot count number of documents in collection"))
+			ctx.Error(err)
+			return
+		}
+
+		if n > 0 {
+			ctx.AbortWithError(http.StatusConflict, errors.New("only empty collections can change their type"))
+			return
+		}
+
+		after.Type = body.Type
+
+		if after.Type != models.TypeAsset {
+			after.Storage = ""
+			after.Prefix = ""
+		} else {
+			after.Prefix = newPrefix(collName)
+		}
+
+		if after.Type != models.TypeCustom {
+			after.Schema = nil
+		}
+	}
+
+	// schema: existing documents are not revalidated, only documents written afterwards
+
+	if body.Schema != nil {
+		after.Schema = body.Schema
+	}
 
-		// log.Printf("error on collection %v, type %v: %v", body.Name, body.Type, errors.New("collection type is not implemented"))
-		ctx.AbortWithError(http.StatusInternalServerError, errors.New("collection type is not implemented"))
+	if err := checkSchema(after); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
 		return
+	}
+
+	// settings are replaced as a whole
 
+	if body.Settings != nil {
+		after.Settings = body.Settings
 	}
 
-	ctx.Status(http.StatusCreated)
+	if err := after.settings().Validate(); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	// storage: only new files are written to the new store, existing files stay where they are
+
+	if body.Storage != "" {
+		after.Storage = body.Storage
+	}
+
+	if err := c.checkStorage(after); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	// name: the previous name is kept as an alias
+
+	renamed := body.Name != "" && body.Name != before.Name
+
+	if renamed {
+
+		if !validName(body.Name) {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid collection name"))
+			return
+		}
+
+		if reserved(body.Name) {
+			ctx.AbortWithError(http.StatusConflict, errors.New("collection name is in conflict with the router's internal routes"))
+			return
+		}
+
+		taken, err := c.nameTaken(ctx.Request.Context(), body.Name, before.Name)
+
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot check conflict"))
+			ctx.Error(err)
+			return
+		}
+
+		if taken {
+			ctx.AbortWithError(http.StatusConflict, errors.New("collection name is in conflict existing collection(s)"))
+			return
+		}
+
+		after.Name = body.Name
+		after.Aliases = []string{before.Name}
+
+		// the files keep their keys
+		if after.Type == models.TypeAsset && after.Prefix == "" {
+			after.Prefix = before.Name
+		}
+
+		for _, alias := range before.Aliases {
+			if alias != after.Name {
+				after.Aliases = append(after.Aliases, alias)
+			}
+		}
+	}
+
+	// aliases can be set explicitly, e.g. to free a previous name
+
+	if body.Aliases != nil {
+		after.Aliases = body.Aliases
+	}
+
+	for _, alias := range after.Aliases {
+
+		if alias == after.Name || !validName(alias) || reserved(alias) {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid alias: "+alias))
+			return
+		}
+
+		taken, err := c.nameTaken(ctx.Request.Context(), alias, before.Name)
+
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot check conflict"))
+			ctx.Error(err)
+			return
+		}
+
+		if taken {
+			ctx.AbortWithError(http.StatusConflict, errors.New("alias is in conflict existing collection(s): "+alias))
+			return
+		}
+	}
+
+	// rename the documents, then replace the meta document (its _id changes)
+
+	if renamed {
+
+		// the steps are undone if a later one fails, so that the collection is left under its previous name
+		var undo []func(ctx context.Context) error
+
+		rollback := func() {
+			for i := len(undo) - 1; i >= 0; i-- {
+				if err := undo[i](context.Background()); err != nil {
+					log.Printf("cannot undo rename of collection %v to %v: %v", before.Name, after.Name, err)
+				}
+			}
+		}
+
+		// nothing to rename if the collection was never written to
+		err := c.rename(ctx.Request.Context(), before.Name, after.Name)
+
+		if err != nil && !helpers.IsNamespaceNotFound(err) {
+			if helpers.IsNamespaceExists(err) {
+				ctx.AbortWithError(http.StatusConflict, errors.New("a mongo collection with the new name already exists"))
+			} else {
+				ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot rename collection"))
+			}
+			ctx.Error(err)
+			return
+		}
+
+		if err == nil {
+			undo = append(undo, func(ctx context.Context) error {
+				return c.rename(ctx, after.Name, before.Name)
+			})
+		}
+
+		if before.Type == models.TypePage {
+
+			if err := handlers.MoveRevisions(ctx.Request.Context(), c.DB, before.Name, after.Name); err != nil {
+				rollback()
+				ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot rename revisions"))
+				ctx.Error(err)
+				return
+			}
+
+			undo = append(undo, func(ctx context.Context) error {
+				return handlers.MoveRevisions(ctx, c.DB, after.Name, before.Name)
+			})
+		}
+
+		if _, err := c.DB.Collection(metaCollection).InsertOne(ctx.Request.Context(), after); err != nil {
+			rollback()
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot insert document"))
+			ctx.Error(err)
+			return
+		}
+
+		undo = append(undo, func(ctx context.Context) error {
+			_, err := c.DB.Collection(metaCollection).DeleteOne(ctx, bson.M{"_id": after.Name})
+			return err
+		})
+
+		if _, err := c.DB.Collection(metaCollection).DeleteOne(ctx.Request.Context(), bson.M{"_id": before.Name}); err != nil {
+			rollback()
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot delete document"))
+			ctx.Error(err)
+			return
+		}
+
+	} else {
+
+		if _, err := c.DB.Collection(metaCollection).ReplaceOne(ctx.Request.Context(), bson.M{"_id": before.Name}, after); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot update document"))
+			ctx.Error(err)
+			return
+		}
+	}
+
+	// Live replace the routes
+
+	if err := c.mount(ctx.Request.Context(), after); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot register collection"))
+		ctx.Error(err)
+		return
+	}
+
+	if renamed {
+		c.collections.remove(before.Name)
+	}
+
+	c.Audit.Record(ctx, audit.ActionUpdate, after.Name, nil, before, after)
+
+	ctx.JSON(http.StatusOK, after)
 }
 
 func (c *CollectionDelegate) deleteCollHandler(ctx *gin.Context) {
@@ -179,6 +550,15 @@ func (c *CollectionDelegate) deleteCollHandler(ctx *gin.Context) {
 		return
 	}
 
+	// what happens to the documents: kept (default), dropped, or archived into another collection
+
+	data := ctx.DefaultQuery("data", "keep")
+
+	if data != "keep" && data != "drop" && data != "archive" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("data should be keep, drop or archive"))
+		return
+	}
+
 	filter := bson.M{
 		"_id": collName,
 	}
@@ -195,5 +575,183 @@ func (c *CollectionDelegate) deleteCollHandler(ctx *gin.Context) {
 		return
 	}
 
+	var before MetaCollectionModel
+
+	if err := res.Decode(&before); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// unregister the routes
+
+	c.collections.remove(collName)
+
+	c.Audit.Record(ctx, audit.ActionDelete, collName, nil, before, nil)
+
+	switch data {
+
+	case "drop":
+		if before.Type == models.TypeAsset {
+			h := c.assetHandler(before)
+
+			if err := h.DeleteFiles(ctx.Request.Context()); err != nil {
+				ctx.AbortWithError(http.StatusInternalServerError, errors.New("collection deleted, but files cannot be removed"))
+				ctx.Error(err)
+				return
+			}
+		}
+
+		if err := c.DB.Collection(collName).Drop(ctx.Request.Context()); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("collection deleted, but documents cannot be dropped"))
+			ctx.Error(err)
+			return
+		}
+
+		if before.Type == models.TypePage {
+			if err := handlers.DeleteRevisions(ctx.Request.Context(), c.DB, collName); err != nil {
+				ctx.AbortWithError(http.StatusInternalServerError, errors.New("collection deleted, but revisions cannot be removed"))
+				ctx.Error(err)
+				return
+			}
+		}
+
+	case "archive":
+		archive, err := c.archive(ctx.Request.Context(), collName)
+
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("collection deleted, but documents cannot be archived"))
+			ctx.Error(err)
+			return
+		}
+
+		if archive != "" {
+			log.Printf("meta: documents of deleted collection %v archived into %v", collName, archive)
+		}
+
+		// the revisions follow the documents
+
+		if archive != "" && before.Type == models.TypePage {
+			if err := handlers.MoveRevisions(ctx.Request.Context(), c.DB, collName, archive); err != nil {
+				ctx.AbortWithError(http.StatusInternalServerError, errors.New("collection deleted, but revisions cannot be archived"))
+				ctx.Error(err)
+				return
+			}
+		}
+	}
+
 	ctx.Status(http.StatusNoContent)
 }
+
+// archive renames the mongo collection out of the way, to archive.<name>.<unix timestamp>
+func (c *CollectionDelegate) archive(ctx context.Context, name string) (string, error) {
+
+	archive := fmt.Sprintf("archive.%v.%v", name, time.Now().Unix())
+
+	err := c.rename(ctx, name, archive)
+
+	// nothing to archive if the collection was never written to
+	if helpers.IsNamespaceNotFound(err) {
+		return "", nil
+	}
+
+	return archive, err
+}
+
+// rename renames a mongo collection. It fails if a collection with the new name exists.
+func (c *CollectionDelegate) rename(ctx context.Context, from string, to string) error {
+
+	res := c.DB.Client().Database("admin").RunCommand(ctx, bson.D{
+		{Key: "renameCollection", Value: c.DB.Name() + "." + from},
+		{Key: "to", Value: c.DB.Name() + "." + to},
+	})
+
+	return res.Err()
+}
+
+// validType reports whether collections of the type can be served.
+func validType(t models.ObjectType) bool {
+	return t == models.TypePage || t == models.TypeRef || t == models.TypeAsset || t == models.TypeCustom
+}
+
+// checkSchema checks that a custom collection has a valid schema. Other types have no schema.
+func checkSchema(meta MetaCollectionModel) error {
+
+	if meta.Type != models.TypeCustom {
+		if meta.Schema != nil {
+			return errors.New("only custom collections have a schema")
+		}
+		return nil
+	}
+
+	if meta.Schema == nil {
+		return errors.New("custom collections need a schema")
+	}
+
+	if _, err := handlers.CompileSchema(meta.Schema); err != nil {
+		return errors.New("invalid schema: " + err.Error())
+	}
+
+	return nil
+}
+
+// checkStorage checks that the storage of an asset collection is configured. Other types have no storage.
+func (c *CollectionDelegate) checkStorage(meta MetaCollectionModel) error {
+
+	if meta.Type != models.TypeAsset {
+		if meta.Storage != "" {
+			return errors.New("only asset collections have a storage")
+		}
+		return nil
+	}
+
+	if _, ok := c.Stores[c.assetHandler(meta).Storage]; !ok {
+		return errors.New("storage backend is not configured: " + meta.Storage)
+	}
+
+	return nil
+}
+
+// namePattern is the pattern of collection names and aliases: they are a path segment and a mongo collection name.
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// reservedNames are the router's internal routes, and the mongo collections of the other packages.
+var reservedNames = map[string]bool{
+	"coll":  true,
+	"auth":  true,
+	"users": true,
+	"audit": true,
+
+	metaCollection:              true,
+	handlers.RevisionCollection: true,
+	"sessions":                  true,
+	"throttle":                  true,
+	"tokens":                    true,
+	"signing_keys":              true,
+	"credentials":               true,
+	"ceremonies":                true,
+}
+
+// validName reports whether the name can be used as the name of a collection.
+func validName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// reserved reports whether the name is in conflict with the router's internal routes, or with an internal mongo
+// collection.
+func reserved(name string) bool {
+	return reservedNames[name]
+}
+
+// nameTaken reports whether the name is used by a collection (other than except), or as an alias of one.
+func (c *CollectionDelegate) nameTaken(ctx context.Context, name string, except string) (bool, error) {
+
+	count, err := c.DB.Collection(metaCollection).CountDocuments(ctx, bson.M{
+		"_id": bson.M{"$ne": except},
+		"$or": bson.A{
+			bson.M{"_id": name},
+			bson.M{"aliases": name},
+		},
+	})
+
+	return count > 0, err
+}
@@ -4,9 +4,13 @@ import (
 	"context"
 	"errors"
 	"log"
+	"time"
 
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
 	"github.com/lexffe/backend.lexffe.io/handlers"
 	"github.com/lexffe/backend.lexffe.io/models"
+	"github.com/lexffe/backend.lexffe.io/storage"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
@@ -21,6 +25,9 @@ Logic
 		- register to router
 	- get all documents with the filter { "type": "asset" }
 		- register to router
+	- get all documents with the filter { "type": "custom" }
+		- compile the schema, register to router
+- collections no longer in meta are unregistered
 */
 
 // Bootstrap finds all registered collections (in meta) and registers the routes
@@ -34,6 +41,8 @@ func (c *CollectionDelegate) Bootstrap(ctx context.Context) error {
 
 	defer cur.Close(ctx)
 
+	live := map[string]bool{}
+
 	for cur.Next(ctx) {
 		var result MetaCollectionModel
 
@@ -41,33 +50,156 @@ func (c *CollectionDelegate) Bootstrap(ctx context.Context) error {
 			return err
 		}
 
-		switch result.Type {
+		live[result.Name] = true
 
-		case models.TypePage:
-			h := handlers.PageHandler{
-				Router:     c.Engine.Group(result.Name),
-				DB:         c.DB,
-				PageType:   result.Type,
-				Collection: result.Name,
-			}
-			h.RegisterRoutes()
-
-		case models.TypeRef:
-			h := handlers.ReferenceHandler{
-				Router:        c.Engine.Group(result.Name),
-				DB:            c.DB,
-				ReferenceType: result.Type,
-				Collection:    result.Name,
+		// unchanged collections keep their engine
+
+		if !c.collections.changed(result) {
+			continue
+		}
+
+		if err := c.mount(ctx, result); err != nil {
+			log.Printf("error on collection %v, type %v: %v", result.Name, result.Type, err)
+		}
+	}
+
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range c.collections.names() {
+		if !live[name] {
+			c.collections.remove(name)
+		}
+	}
+
+	return nil
+}
+
+// Watch re-runs Bootstrap periodically until the context is cancelled, so that collections changed by other
+// instances are picked up.
+func (c *CollectionDelegate) Watch(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Bootstrap(ctx); err != nil {
+				log.Printf("meta: cannot refresh collections: %v", err)
 			}
-			h.RegisterRoutes()
+		}
+	}
+}
+
+// collectionHandler serves the documents of a collection.
+type collectionHandler interface {
+	RegisterRoutes()
+	EnsureIndexes(ctx context.Context) error
+}
+
+// mount builds the engine of a collection, and makes it live (replacing the previous engine, if any).
+func (c *CollectionDelegate) mount(ctx context.Context, meta MetaCollectionModel) error {
+
+	// e.g. created before names were checked
+	if !validName(meta.Name) || reserved(meta.Name) {
+		return errors.New("invalid or reserved collection name")
+	}
+
+	engine := gin.New()
+	engine.Use(inherit)
 
-		default:
+	var h collectionHandler
 
-			log.Printf("error on collection %v, type %v: %v", result.Name, result.Type, errors.New("collection type is not implemented"))
+	switch meta.Type {
+
+	case models.TypePage:
+		h = &handlers.PageHandler{
+			Router:     engine.Group(meta.Name),
+			DB:         c.DB,
+			PageType:   meta.Type,
+			Collection: meta.Name,
+			Audit:      c.Audit,
+			Settings:   meta.settings(),
+		}
 
+	case models.TypeRef:
+		h = &handlers.ReferenceHandler{
+			Router:        engine.Group(meta.Name),
+			DB:            c.DB,
+			ReferenceType: meta.Type,
+			Collection:    meta.Name,
+			Audit:         c.Audit,
+			Settings:      meta.settings(),
 		}
 
+	case models.TypeAsset:
+		a := c.assetHandler(meta)
+		a.Router = engine.Group(meta.Name)
+		h = &a
+
+	case models.TypeCustom:
+		schema, err := handlers.CompileSchema(meta.Schema)
+
+		if err != nil {
+			return err
+		}
+
+		h = &handlers.CustomHandler{
+			Router:     engine.Group(meta.Name),
+			DB:         c.DB,
+			Collection: meta.Name,
+			Audit:      c.Audit,
+			Schema:     schema,
+			Settings:   meta.settings(),
+		}
+
+	default:
+		return errors.New("collection type is not implemented")
+	}
+
+	h.RegisterRoutes()
+
+	// indexes that cannot be created (e.g. unique, with duplicates) are logged, the collection is served anyway
+
+	if err := h.EnsureIndexes(ctx); err != nil {
+		log.Printf("collection %v: cannot ensure indexes: %v", meta.Name, err)
 	}
 
+	m := mount{meta: meta, engine: engine}
+
+	// additional cors origins of the collection
+
+	if origins := meta.settings().CORSOrigins; len(origins) > 0 {
+		config := c.CORS
+		config.AllowOrigins = append(append([]string{}, c.CORS.AllowOrigins...), origins...)
+		m.cors = cors.New(config)
+	}
+
+	c.collections.set(m)
+
 	return nil
 }
+
+// assetHandler returns the handler of an asset collection, without routes.
+func (c *CollectionDelegate) assetHandler(meta MetaCollectionModel) handlers.AssetHandler {
+
+	h := handlers.AssetHandler{
+		DB:         c.DB,
+		Collection: meta.Name,
+		Prefix:     meta.prefix(),
+		Audit:      c.Audit,
+		Stores:     c.Stores,
+		Storage:    meta.Storage,
+		Settings:   meta.settings(),
+	}
+
+	if h.Storage == "" {
+		h.Storage = storage.Local
+	}
+
+	return h
+}
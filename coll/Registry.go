@@ -0,0 +1,189 @@
+package coll
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+/**
+Routing
+
+gin cannot remove routes, and a /:collection/*path route would collide with the static routes (/auth, /coll, ...).
+Instead, every collection gets its own engine, kept in a registry. The main engine dispatches requests that match
+none of its routes (NoRoute) to the engine of the collection in the first path segment.
+
+Collections can then be added, replaced and removed at runtime by swapping the engines.
+*/
+
+// parentKey is the request context key of the context of the main engine.
+type parentKey struct{}
+
+// mount is a live collection.
+type mount struct {
+	meta   MetaCollectionModel
+	engine *gin.Engine
+
+	// cors is the cors middleware of a collection with additional origins, nil otherwise.
+	cors gin.HandlerFunc
+}
+
+// registry holds the engines of the live collections, and the aliases (previous names) of the collections.
+type registry struct {
+	mu      sync.RWMutex
+	mounts  map[string]mount
+	aliases map[string]string
+}
+
+func newRegistry() *registry {
+	return &registry{mounts: map[string]mount{}, aliases: map[string]string{}}
+}
+
+func (r *registry) get(name string) (mount, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, ok := r.mounts[name]
+	return m, ok
+}
+
+// alias returns the current name of a renamed collection.
+func (r *registry) alias(name string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	target, ok := r.aliases[name]
+	return target, ok
+}
+
+func (r *registry) set(m mount) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.unalias(m.meta.Name)
+
+	r.mounts[m.meta.Name] = m
+
+	for _, alias := range m.meta.Aliases {
+		r.aliases[alias] = m.meta.Name
+	}
+}
+
+func (r *registry) remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.unalias(name)
+
+	delete(r.mounts, name)
+}
+
+// unalias removes the aliases of a collection. The lock must be held.
+func (r *registry) unalias(name string) {
+	for alias, target := range r.aliases {
+		if target == name {
+			delete(r.aliases, alias)
+		}
+	}
+}
+
+// names returns the names of the live collections.
+func (r *registry) names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.mounts))
+
+	for name := range r.mounts {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// changed reports whether the collection is not live, or live with different metadata.
+func (r *registry) changed(meta MetaCollectionModel) bool {
+	m, ok := r.get(meta.Name)
+	return !ok || !reflect.DeepEqual(m.meta, meta)
+}
+
+// collectionName returns the first path segment of the request, the name of a collection.
+func collectionName(ctx *gin.Context) string {
+	return strings.SplitN(strings.TrimPrefix(ctx.Request.URL.Path, "/"), "/", 2)[0]
+}
+
+// CORSMiddleware handles cors with the configuration of the collection of the request, if it allows additional
+// origins, or the global configuration. It has to run before the routes of the main engine.
+func (c *CollectionDelegate) CORSMiddleware() gin.HandlerFunc {
+
+	global := cors.New(c.CORS)
+
+	return func(ctx *gin.Context) {
+
+		if c.collections != nil {
+			if m, ok := c.collections.get(collectionName(ctx)); ok && m.cors != nil {
+				m.cors(ctx)
+				return
+			}
+		}
+
+		global(ctx)
+	}
+}
+
+// dispatch serves the request with the engine of the collection in the first path segment.
+func (c *CollectionDelegate) dispatch(ctx *gin.Context) {
+
+	name := collectionName(ctx)
+
+	// previous names of renamed collections are redirected, keeping the rest of the path and the query
+
+	if target, ok := c.collections.alias(name); ok {
+		u := *ctx.Request.URL
+		u.Path = "/" + target + strings.TrimPrefix(u.Path, "/"+name)
+
+		ctx.Redirect(http.StatusPermanentRedirect, u.String())
+		ctx.Abort()
+		return
+	}
+
+	m, ok := c.collections.get(name)
+
+	if !ok {
+		// not a collection, leave it to the 404 handler
+		return
+	}
+
+	req := ctx.Request.WithContext(context.WithValue(ctx.Request.Context(), parentKey{}, ctx))
+
+	m.engine.ServeHTTP(ctx.Writer, req)
+
+	// the collection engine has responded, even if it did not write a body
+	ctx.Writer.WriteHeaderNow()
+	ctx.Abort()
+}
+
+// inherit copies the keys set by the middlewares of the main engine (e.g. Authorized, Identity) into the context
+// of the collection engine, and the errors of the collection engine back.
+func inherit(ctx *gin.Context) {
+
+	parent, ok := ctx.Request.Context().Value(parentKey{}).(*gin.Context)
+
+	if !ok {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	for k, v := range parent.Keys {
+		ctx.Set(k, v)
+	}
+
+	ctx.Next()
+
+	parent.Errors = append(parent.Errors, ctx.Errors...)
+}
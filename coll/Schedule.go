@@ -0,0 +1,48 @@
+package coll
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/lexffe/backend.lexffe.io/handlers"
+	"github.com/lexffe/backend.lexffe.io/models"
+)
+
+// Schedule publishes and unpublishes the scheduled pages of the live page collections, right away and then
+// periodically until the context is cancelled. Pages whose time passed while the server was down are caught up on
+// the first run.
+func (c *CollectionDelegate) Schedule(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.publishScheduled(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishScheduled runs the scheduler on every live page collection.
+func (c *CollectionDelegate) publishScheduled(ctx context.Context) {
+
+	now := time.Now()
+
+	for _, name := range c.collections.names() {
+
+		m, ok := c.collections.get(name)
+
+		if !ok || m.meta.Type != models.TypePage {
+			continue
+		}
+
+		if err := handlers.PublishScheduled(ctx, c.DB, name, c.Audit, now); err != nil {
+			log.Printf("scheduler: cannot publish scheduled pages of %v: %v", name, err)
+		}
+	}
+}
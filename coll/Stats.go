@@ -0,0 +1,170 @@
+package coll
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/helpers"
+	"github.com/lexffe/backend.lexffe.io/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// collectionStats are the statistics of a collection, for the dashboard.
+type collectionStats struct {
+	// Documents is the number of documents. Page collections only: Drafts is the number of documents with
+	// "published": false, Published the others.
+	Documents int64  `json:"documents" bson:"documents"`
+	Published *int64 `json:"published,omitempty" bson:"-"`
+	Drafts    *int64 `json:"drafts,omitempty" bson:"drafts,omitempty"`
+
+	// MarkdownSize is the total size of the markdown of the documents in bytes.
+	MarkdownSize int64 `json:"markdown_size" bson:"markdown_size"`
+
+	// FilesSize is the total size of the files of an asset collection in bytes.
+	FilesSize int64 `json:"files_size,omitempty" bson:"files_size"`
+
+	// LastUpdated is the time the most recent document was updated (or created).
+	LastUpdated *time.Time `json:"last_updated" bson:"last_updated"`
+
+	// Tags is the frequency of the tags of the documents, most used first.
+	Tags []tagCount `json:"tags" bson:"-"`
+
+	// Storage is reported by mongo (collStats).
+	Storage storageStats `json:"storage" bson:"-"`
+}
+
+type tagCount struct {
+	Tag   interface{} `json:"tag" bson:"_id"`
+	Count int64       `json:"count" bson:"count"`
+}
+
+type storageStats struct {
+	// Size is the size of the documents, StorageSize the size on disk (compressed), IndexSize the size of the indexes.
+	Size        int64 `json:"size" bson:"size"`
+	StorageSize int64 `json:"storage_size" bson:"storageSize"`
+	IndexSize   int64 `json:"index_size" bson:"totalIndexSize"`
+}
+
+// getStatsHandler returns the statistics of a collection.
+func (c *CollectionDelegate) getStatsHandler(ctx *gin.Context) {
+
+	res := c.DB.Collection(metaCollection).FindOne(ctx.Request.Context(), bson.M{"_id": ctx.Param("name")})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.Status(http.StatusInternalServerError)
+			ctx.Error(res.Err())
+		}
+		return
+	}
+
+	var meta MetaCollectionModel
+
+	if err := res.Decode(&meta); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// documents: the totals and the tag frequency, in one aggregation
+
+	totals := bson.M{
+		"_id":       nil,
+		"documents": bson.M{"$sum": 1},
+		"markdown_size": bson.M{"$sum": bson.M{
+			"$cond": bson.A{bson.M{"$eq": bson.A{bson.M{"$type": "$markdown"}, "string"}}, bson.M{"$strLenBytes": "$markdown"}, 0},
+		}},
+		// pages have last_updated, assets created, other documents only the time of their _id, if it is an ObjectId
+		"last_updated": bson.M{"$max": bson.M{
+			"$ifNull": bson.A{"$last_updated", bson.M{"$ifNull": bson.A{"$created", bson.M{
+				"$cond": bson.A{bson.M{"$eq": bson.A{bson.M{"$type": "$_id"}, "objectId"}}, bson.M{"$toDate": "$_id"}, nil},
+			}}}},
+		}},
+	}
+
+	if meta.Type == models.TypePage {
+		totals["drafts"] = bson.M{"$sum": bson.M{
+			"$cond": bson.A{bson.M{"$eq": bson.A{"$published", false}}, 1, 0},
+		}}
+	}
+
+	if meta.Type == models.TypeAsset {
+		totals["files_size"] = bson.M{"$sum": "$size"}
+	}
+
+	pipeline := bson.A{
+		bson.M{"$facet": bson.M{
+			"totals": bson.A{
+				bson.M{"$group": totals},
+			},
+			"tags": bson.A{
+				bson.M{"$unwind": "$tags"},
+				bson.M{"$group": bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			},
+		}},
+	}
+
+	cur, err := c.DB.Collection(meta.Name).Aggregate(ctx.Request.Context(), pipeline)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot aggregate documents"))
+		ctx.Error(err)
+		return
+	}
+
+	var facets []struct {
+		Totals []collectionStats `bson:"totals"`
+		Tags   []tagCount        `bson:"tags"`
+	}
+
+	if err := cur.All(ctx.Request.Context(), &facets); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot aggregate documents"))
+		ctx.Error(err)
+		return
+	}
+
+	var stats collectionStats
+
+	if len(facets) == 1 {
+
+		if len(facets[0].Totals) == 1 {
+			stats = facets[0].Totals[0]
+		}
+
+		stats.Tags = facets[0].Tags
+	}
+
+	if stats.Tags == nil {
+		stats.Tags = []tagCount{}
+	}
+
+	// only pages are published or drafts
+	if meta.Type == models.TypePage {
+
+		var drafts int64
+
+		if stats.Drafts != nil {
+			drafts = *stats.Drafts
+		}
+
+		published := stats.Documents - drafts
+		stats.Drafts, stats.Published = &drafts, &published
+	}
+
+	// storage: collStats, a collection that was never written to has no storage
+
+	err = c.DB.RunCommand(ctx.Request.Context(), bson.D{{Key: "collStats", Value: meta.Name}}).Decode(&stats.Storage)
+
+	if err != nil && !helpers.IsNamespaceNotFound(err) {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot get storage statistics"))
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,103 @@
+package coll
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestStatsHandler(t *testing.T) {
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	// stats responds with the statistics of the collection name, of the type, from the given totals
+	stats := func(mt *mtest.T, name string, typ models.ObjectType, totals bson.D) map[string]interface{} {
+
+		c := &CollectionDelegate{DB: mt.DB}
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.meta", mtest.FirstBatch, bson.D{{Key: "_id", Value: name}, {Key: "type", Value: typ}}),
+			mtest.CreateCursorResponse(0, "test."+name, mtest.FirstBatch, bson.D{
+				{Key: "totals", Value: bson.A{totals}},
+				{Key: "tags", Value: bson.A{}},
+			}),
+			mtest.CreateSuccessResponse(bson.E{Key: "size", Value: int32(10)}),
+		)
+
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.GET("/coll/:name/stats", c.getStatsHandler)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/coll/"+name+"/stats", nil))
+
+		if w.Code != http.StatusOK {
+			mt.Fatalf("status = %v, want 200", w.Code)
+		}
+
+		// the pipeline of the aggregation, the second command
+		mt.GetStartedEvent()
+		pipeline := mt.GetStartedEvent().Command.Lookup("pipeline").String()
+
+		if strings.Contains(pipeline, `"$toDate"`) && !strings.Contains(pipeline, `"objectId"`) {
+			mt.Errorf("$toDate of _id is not guarded by its type: %v", pipeline)
+		}
+
+		if typ != models.TypePage && strings.Contains(pipeline, `"drafts"`) {
+			mt.Errorf("drafts are counted in a %v collection", typ)
+		}
+
+		var body map[string]interface{}
+
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			mt.Fatal(err)
+		}
+
+		return body
+	}
+
+	mt.Run("page", func(mt *mtest.T) {
+
+		body := stats(mt, "posts", models.TypePage, bson.D{
+			{Key: "documents", Value: int32(5)},
+			{Key: "drafts", Value: int32(2)},
+		})
+
+		if body["published"] != float64(3) || body["drafts"] != float64(2) {
+			mt.Errorf("published, drafts = %v, %v, want 3, 2", body["published"], body["drafts"])
+		}
+	})
+
+	mt.Run("page without drafts", func(mt *mtest.T) {
+
+		body := stats(mt, "posts", models.TypePage, bson.D{
+			{Key: "documents", Value: int32(5)},
+			{Key: "drafts", Value: int32(0)},
+		})
+
+		if body["published"] != float64(5) || body["drafts"] != float64(0) {
+			mt.Errorf("published, drafts = %v, %v, want 5, 0", body["published"], body["drafts"])
+		}
+	})
+
+	mt.Run("reference", func(mt *mtest.T) {
+
+		body := stats(mt, "links", models.TypeRef, bson.D{{Key: "documents", Value: int32(5)}})
+
+		if _, ok := body["published"]; ok {
+			mt.Errorf("references are published: %v", body)
+		}
+
+		if _, ok := body["drafts"]; ok {
+			mt.Errorf("references are drafts: %v", body)
+		}
+	})
+}
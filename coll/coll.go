@@ -1,8 +1,12 @@
 package coll
 
 import (
+	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/audit"
 	"github.com/lexffe/backend.lexffe.io/models"
+	"github.com/lexffe/backend.lexffe.io/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -17,6 +21,14 @@ const metaCollection = "meta"
 type CollectionDelegate struct {
 	Engine *gin.Engine
 	DB     *mongo.Database
+	Audit  *audit.Log
+	Stores map[string]storage.Store
+
+	// CORS is the cors configuration of every route. Collections can allow additional origins, see CORSMiddleware.
+	CORS cors.Config
+
+	// collections are the live collections, served by dispatch.
+	collections *registry
 }
 
 // MetaCollectionModel is a metadata document describing all the collections in the database
@@ -26,4 +38,45 @@ type MetaCollectionModel struct {
 
 	// Type is the collection type.
 	Type models.ObjectType `json:"type" bson:"type"`
+
+	// Aliases are the previous names of the collection, redirected to the current name.
+	Aliases []string `json:"aliases,omitempty" bson:"aliases,omitempty"`
+
+	// Storage is the blob store new files of an asset collection are written to. Defaults to local.
+	Storage string `json:"storage,omitempty" bson:"storage,omitempty"`
+
+	// Prefix is the prefix of the keys of the files of an asset collection, set when it is created and kept when
+	// it is renamed, so that a new collection with a previous name does not share its files. Defaults to the name.
+	Prefix string `json:"prefix,omitempty" bson:"prefix,omitempty"`
+
+	// Schema is the JSON Schema the documents of a custom collection are validated against.
+	Schema models.Schema `json:"schema,omitempty" bson:"schema,omitempty"`
+
+	// Settings configure the behaviour of the collection, defaults if nil.
+	Settings *models.CollectionSettings `json:"settings,omitempty" bson:"settings,omitempty"`
+}
+
+// settings returns the settings of the collection.
+func (m MetaCollectionModel) settings() models.CollectionSettings {
+
+	if m.Settings == nil {
+		return models.CollectionSettings{}
+	}
+
+	return *m.Settings
+}
+
+// prefix returns the prefix of the keys of the files of the collection.
+func (m MetaCollectionModel) prefix() string {
+
+	if m.Prefix == "" {
+		return m.Name
+	}
+
+	return m.Prefix
+}
+
+// newPrefix returns a prefix of keys of files for a new asset collection, unique even if the name was used before.
+func newPrefix(name string) string {
+	return name + "-" + primitive.NewObjectID().Hex()
 }
@@ -1,3 +1,701 @@
 package handlers
 
-// TODO:
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/audit"
+	"github.com/lexffe/backend.lexffe.io/auth"
+	"github.com/lexffe/backend.lexffe.io/helpers"
+	"github.com/lexffe/backend.lexffe.io/imaging"
+	"github.com/lexffe/backend.lexffe.io/models"
+	"github.com/lexffe/backend.lexffe.io/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/singleflight"
+)
+
+// maxUploadSize is the maximum size of an uploaded file.
+const maxUploadSize = 32 << 20
+
+// derivativeWidths are the widths images can be requested in.
+var derivativeWidths = map[int]bool{160: true, 320: true, 640: true, 960: true, 1280: true, 1920: true, 2560: true}
+
+// inlineTypes are the content types displayed inline: raster images, which cannot run script.
+var inlineTypes = map[string]bool{"image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true, "image/bmp": true}
+
+// derivations deduplicates the generation of derivatives, by key.
+var derivations singleflight.Group
+
+// AssetHandler is a helper struct for all asset handlers.
+type AssetHandler struct {
+	Router     *gin.RouterGroup
+	DB         *mongo.Database
+	Collection string
+	Audit      *audit.Log
+
+	// Prefix is the prefix of the keys of the files, i.e. files are stored as <prefix>/<sha256>.
+	Prefix string
+
+	// Settings are the settings of the collection, from its meta document.
+	Settings models.CollectionSettings
+
+	// Stores are the configured blob stores, by name. New files are written to Storage.
+	Stores  map[string]storage.Store
+	Storage string
+}
+
+// RegisterRoutes sets the router routes.
+func (s *AssetHandler) RegisterRoutes() {
+	reads := s.Router.Group("/")
+
+	// private collections can only be read with the read scope
+	if s.Settings.Private {
+		reads.Use(auth.CheckAuthentication("read:" + s.Collection))
+	}
+
+	reads.GET("/", s.getAssetsHandler)
+	reads.GET("/:id", s.getAssetHandler)
+	reads.GET("/:id/raw", s.getAssetRawHandler)
+
+	protected := s.Router.Group("/", auth.CheckAuthentication("write:"+s.Collection))
+
+	protected.POST("/", s.createAssetHandler)
+	protected.DELETE("/:id", s.deleteAssetHandler)
+}
+
+// EnsureIndexes creates the indexes of the collection.
+func (s *AssetHandler) EnsureIndexes(ctx context.Context) error {
+
+	indexes := []mongo.IndexModel{
+		// content-hash dedup
+		{Keys: bson.M{"hash": 1}, Options: options.Index().SetUnique(true)},
+	}
+
+	return ensureIndexes(ctx, s.DB.Collection(s.Collection), append(indexes, sortIndexes(s.Settings)...))
+}
+
+// directory
+func (s *AssetHandler) getAssetsHandler(ctx *gin.Context) {
+
+	// user-defined skip, for pagination.
+	skip, err := strconv.ParseInt(ctx.DefaultQuery("skip", "0"), 10, 64)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("skip is not a number"))
+		ctx.Error(err)
+		return
+	}
+
+	// user-defined limit, for pagination
+	limit, err := strconv.ParseInt(ctx.DefaultQuery("limit", "0"), 10, 64)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("limit is not a number"))
+		ctx.Error(err)
+		return
+	}
+
+	// get length of the collection (for pagination)
+
+	count, err := s.DB.Collection(s.Collection).CountDocuments(ctx.Request.Context(), bson.M{})
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot count number of documents in collection"))
+		ctx.Error(err)
+		return
+	}
+
+	ctx.Header("X-Collection-Length", strconv.FormatInt(count, 10))
+
+	opts := options.Find().
+		SetLimit(s.Settings.Limit(limit)).
+		SetSkip(skip).
+		SetSort(s.Settings.SortOrder())
+
+	cur, err := s.DB.Collection(s.Collection).Find(ctx.Request.Context(), bson.M{}, opts)
+
+	// mongo related error
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	results := []models.Asset{}
+
+	if err := cur.All(ctx.Request.Context(), &results); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, results)
+}
+
+func (s *AssetHandler) getAssetHandler(ctx *gin.Context) {
+
+	doc, ok := s.findAsset(ctx)
+
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, doc)
+}
+
+// getAssetRawHandler streams the file. Range requests and conditional requests (ETag) are handled by http.ServeContent.
+func (s *AssetHandler) getAssetRawHandler(ctx *gin.Context) {
+
+	helpers.SetDeadline(ctx.Request.Context(), helpers.StreamDeadline)
+
+	doc, ok := s.findAsset(ctx)
+
+	if !ok {
+		return
+	}
+
+	store, ok := s.Stores[doc.Storage]
+
+	if !ok {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("storage backend is not configured: "+doc.Storage))
+		return
+	}
+
+	// images can be requested resized / converted
+
+	if ctx.Query("w") != "" || ctx.Query("fmt") != "" {
+		s.serveDerivative(ctx, doc, store)
+		return
+	}
+
+	f, err := store.Open(ctx.Request.Context(), doc.Key)
+
+	if err != nil {
+		if err == storage.ErrNotFound {
+			ctx.AbortWithError(http.StatusNotFound, errors.New("file of the asset is missing"))
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot open file"))
+			ctx.Error(err)
+		}
+		return
+	}
+
+	defer f.Close()
+
+	// the content never changes for a hash
+
+	ctx.Header("Content-Type", doc.MIMEType)
+	ctx.Header("ETag", `"`+doc.Hash+`"`)
+	ctx.Header("Cache-Control", "public, max-age=31536000, immutable")
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	ctx.Header("Content-Security-Policy", "sandbox")
+	ctx.Header("Content-Disposition", disposition(doc.MIMEType, doc.Name))
+
+	http.ServeContent(ctx.Writer, ctx.Request, doc.Name, doc.Created, f)
+}
+
+// serveDerivative streams an image scaled down to ?w= and converted to ?fmt=. Derivatives are generated on the
+// first request and kept in the store of the asset.
+func (s *AssetHandler) serveDerivative(ctx *gin.Context, doc models.Asset, store storage.Store) {
+
+	if doc.Width == 0 || !imaging.Supported(doc.MIMEType) {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("asset is not an image"))
+		return
+	}
+
+	// widths are limited to a few sizes, every size is a file. images are never scaled up.
+
+	width := doc.Width
+
+	if w := ctx.Query("w"); w != "" {
+
+		n, err := strconv.Atoi(w)
+
+		if err != nil || !derivativeWidths[n] {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("w must be one of 160, 320, 640, 960, 1280, 1920 or 2560"))
+			return
+		}
+
+		if n < width {
+			width = n
+		}
+	}
+
+	format := ctx.DefaultQuery("fmt", imaging.Format(doc.MIMEType))
+	mimeType := imaging.MIMEType(format)
+
+	if mimeType == "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("fmt must be jpeg, png or webp"))
+		return
+	}
+
+	key := fmt.Sprintf("%v.%vw.%v", doc.Key, width, format)
+
+	f, err := store.Open(ctx.Request.Context(), key)
+
+	if err == storage.ErrNotFound {
+
+		// concurrent requests for the same derivative wait for one generation, as long as the first request waits
+		// to decode. a generation that gave up is retried by the next request.
+
+		_, err, _ = derivations.Do(key, func() (interface{}, error) {
+			return nil, s.derive(ctx.Request.Context(), doc, store, key, width, format)
+		})
+
+		if err == nil {
+			f, err = store.Open(ctx.Request.Context(), key)
+		}
+	}
+
+	if err != nil {
+		if err == imaging.ErrTooLarge {
+			ctx.AbortWithError(http.StatusUnprocessableEntity, errors.New("image is too large to be resized"))
+		} else if err == imaging.ErrBusy {
+			ctx.AbortWithError(http.StatusServiceUnavailable, err)
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot generate derivative"))
+			ctx.Error(err)
+		}
+		return
+	}
+
+	defer f.Close()
+
+	name := strings.TrimSuffix(doc.Name, filepath.Ext(doc.Name)) + "." + format
+
+	ctx.Header("Content-Type", mimeType)
+	ctx.Header("ETag", fmt.Sprintf(`"%v-%vw-%v"`, doc.Hash, width, format))
+	ctx.Header("Cache-Control", "public, max-age=31536000, immutable")
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	ctx.Header("Content-Security-Policy", "sandbox")
+	ctx.Header("Content-Disposition", disposition(mimeType, name))
+
+	http.ServeContent(ctx.Writer, ctx.Request, name, doc.Created, f)
+}
+
+// derive generates a derivative of the asset and records it on the document.
+func (s *AssetHandler) derive(ctx context.Context, doc models.Asset, store storage.Store, key string, width int, format string) error {
+
+	f, err := store.Open(ctx, doc.Key)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+
+	if err != nil {
+		return err
+	}
+
+	out, err := imaging.Derive(ctx, data, width, format)
+
+	if err != nil {
+		return err
+	}
+
+	if err := store.Put(ctx, key, bytes.NewReader(out), int64(len(out)), imaging.MIMEType(format)); err != nil {
+		return err
+	}
+
+	// recorded, to be removed with the asset
+
+	_, err = s.DB.Collection(s.Collection).UpdateOne(ctx, bson.M{"_id": doc.ObjectID}, bson.M{
+		"$addToSet": bson.M{"derivatives": key},
+	})
+
+	return err
+}
+
+func (s *AssetHandler) createAssetHandler(ctx *gin.Context) {
+
+	// parse body: multipart/form-data, with the file in "file"
+
+	helpers.SetDeadline(ctx.Request.Context(), helpers.StreamDeadline)
+
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxUploadSize)
+
+	header, err := ctx.FormFile("file")
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed request body, expected a file"))
+		ctx.Error(err)
+		return
+	}
+
+	file, err := header.Open()
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("cannot read file"))
+		ctx.Error(err)
+		return
+	}
+
+	defer file.Close()
+
+	store, ok := s.Stores[s.Storage]
+
+	if !ok {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("storage backend is not configured: "+s.Storage))
+		return
+	}
+
+	// write to a temporary file, hashing on the way. the key of the file is its hash.
+
+	tmp, err := ioutil.TempFile("", "upload-")
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot create file"))
+		ctx.Error(err)
+		return
+	}
+
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	w := io.MultiWriter(tmp, hash)
+
+	// generated fields: { mime_type }, from the first bytes of the content
+
+	head := make([]byte, helpers.SniffLen)
+	n, err := io.ReadFull(file, head)
+
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("cannot read file"))
+		ctx.Error(err)
+		return
+	}
+
+	head = head[:n]
+
+	if _, err := w.Write(head); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot write file"))
+		ctx.Error(err)
+		return
+	}
+
+	rest, err := io.Copy(w, file)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot write file"))
+		ctx.Error(err)
+		return
+	}
+
+	// generated fields: { hash, size, storage, key, created }
+
+	body := models.Asset{
+		Name:     filepath.Base(header.Filename),
+		MIMEType: sniffType(head, header.Filename),
+		Size:     int64(n) + rest,
+		Hash:     hex.EncodeToString(hash.Sum(nil)),
+		Storage:  s.Storage,
+		Created:  time.Now(),
+	}
+
+	// images: metadata is stripped, the stripped file is what is hashed and stored
+
+	var content io.ReadSeeker = tmp
+
+	if imaging.Supported(body.MIMEType) {
+
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot read file"))
+			ctx.Error(err)
+			return
+		}
+
+		raw, err := ioutil.ReadAll(tmp)
+
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot read file"))
+			ctx.Error(err)
+			return
+		}
+
+		// decoding waits for other decodes as long as the request
+
+		stripped, err := imaging.Strip(ctx.Request.Context(), raw, body.MIMEType)
+
+		if err == imaging.ErrBusy {
+			ctx.AbortWithError(http.StatusServiceUnavailable, err)
+			return
+		}
+
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed image"))
+			ctx.Error(err)
+			return
+		}
+
+		// generated fields: { width, height, blurhash }
+
+		info, err := imaging.Analyze(ctx.Request.Context(), stripped)
+
+		if err == imaging.ErrBusy {
+			ctx.AbortWithError(http.StatusServiceUnavailable, err)
+			return
+		}
+
+		if err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("cannot decode image"))
+			ctx.Error(err)
+			return
+		}
+
+		sum := sha256.Sum256(stripped)
+
+		body.Size = int64(len(stripped))
+		body.Hash = hex.EncodeToString(sum[:])
+		body.Width = info.Width
+		body.Height = info.Height
+		body.BlurHash = info.BlurHash
+
+		content = bytes.NewReader(stripped)
+	}
+
+	body.Key = s.Prefix + "/" + body.Hash
+
+	// dedup: the same content is returned as the existing asset
+
+	res := s.DB.Collection(s.Collection).FindOne(ctx.Request.Context(), bson.M{"hash": body.Hash})
+
+	if res.Err() == nil {
+
+		var existing models.Asset
+
+		if err := res.Decode(&existing); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, existing)
+		return
+	}
+
+	if res.Err() != mongo.ErrNoDocuments {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("check existing document failed"))
+		ctx.Error(res.Err())
+		return
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot read file"))
+		ctx.Error(err)
+		return
+	}
+
+	if err := store.Put(ctx.Request.Context(), body.Key, content, body.Size, body.MIMEType); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot store file"))
+		ctx.Error(err)
+		return
+	}
+
+	// database operation
+
+	ins, err := s.DB.Collection(s.Collection).InsertOne(ctx.Request.Context(), body)
+
+	if err != nil {
+		if helpers.IsDuplicateKey(err) {
+			// uploaded concurrently, the file is the same
+			ctx.AbortWithError(http.StatusConflict, errors.New("asset with same content exists"))
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot insert document"))
+			s.removeOrphan(ctx, store, body)
+		}
+		ctx.Error(err)
+		return
+	}
+
+	body.ObjectID = ins.InsertedID.(primitive.ObjectID)
+
+	s.Audit.Record(ctx, audit.ActionCreate, s.Collection, body.ObjectID, nil, body)
+
+	ctx.JSON(http.StatusCreated, body)
+}
+
+// removeOrphan deletes the stored file of an asset that could not be inserted. The file is kept if a document has
+// the same content after all: the insert may have been written, or the same content uploaded concurrently.
+func (s *AssetHandler) removeOrphan(ctx *gin.Context, store storage.Store, body models.Asset) {
+
+	// the request may be cancelled already
+	c := context.Background()
+
+	err := s.DB.Collection(s.Collection).FindOne(c, bson.M{"hash": body.Hash}).Err()
+
+	if err == mongo.ErrNoDocuments {
+		err = store.Delete(c, body.Key)
+	}
+
+	if err != nil {
+		ctx.Error(errors.New("cannot remove file of asset " + body.Key + ": " + err.Error()))
+	}
+}
+
+func (s *AssetHandler) deleteAssetHandler(ctx *gin.Context) {
+
+	// get the document identifier (must be _id)
+	objID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid document identifier"))
+		ctx.Error(err)
+		return
+	}
+
+	res := s.DB.Collection(s.Collection).FindOneAndDelete(ctx.Request.Context(), bson.M{"_id": objID})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.Status(http.StatusInternalServerError)
+		}
+		ctx.Error(res.Err())
+		return
+	}
+
+	var before models.Asset
+
+	if err := res.Decode(&before); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// the file is only used by this asset (hashes are unique)
+
+	if err := s.deleteFile(ctx.Request.Context(), before); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("asset deleted, but file cannot be removed"))
+		ctx.Error(err)
+		return
+	}
+
+	s.Audit.Record(ctx, audit.ActionDelete, s.Collection, objID, before, nil)
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// DeleteFiles removes the files of every asset in the collection, e.g. when the collection is dropped.
+func (s *AssetHandler) DeleteFiles(ctx context.Context) error {
+
+	cur, err := s.DB.Collection(s.Collection).Find(ctx, bson.M{})
+
+	if err != nil {
+		return err
+	}
+
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+
+		var doc models.Asset
+
+		if err := cur.Decode(&doc); err != nil {
+			return err
+		}
+
+		if err := s.deleteFile(ctx, doc); err != nil {
+			return err
+		}
+	}
+
+	return cur.Err()
+}
+
+// deleteFile removes the file of an asset from its store.
+func (s *AssetHandler) deleteFile(ctx context.Context, doc models.Asset) error {
+
+	store, ok := s.Stores[doc.Storage]
+
+	if !ok {
+		return errors.New("storage backend is not configured: " + doc.Storage)
+	}
+
+	for _, key := range doc.Derivatives {
+		if err := store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return store.Delete(ctx, doc.Key)
+}
+
+// findAsset finds the asset of the path, or responds with an error.
+func (s *AssetHandler) findAsset(ctx *gin.Context) (models.Asset, bool) {
+
+	// parse doc id
+	objID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid document identifier"))
+		ctx.Error(err)
+		return models.Asset{}, false
+	}
+
+	res := s.DB.Collection(s.Collection).FindOne(ctx.Request.Context(), bson.M{"_id": objID})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.Status(http.StatusInternalServerError)
+			ctx.Error(res.Err())
+		}
+		return models.Asset{}, false
+	}
+
+	var doc models.Asset
+
+	if err := res.Decode(&doc); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return models.Asset{}, false
+	}
+
+	return doc, true
+}
+
+// disposition returns the Content-Disposition of a file. Files other than raster images (e.g. html or svg) are
+// downloaded, so that they do not run script on the origin of the api.
+func disposition(mimeType string, name string) string {
+
+	kind := "attachment"
+
+	if t, _, err := mime.ParseMediaType(mimeType); err == nil && inlineTypes[t] {
+		kind = "inline"
+	}
+
+	return mime.FormatMediaType(kind, map[string]string{"filename": name})
+}
+
+// sniffType detects the content type from the first bytes of the content. Content that cannot be detected
+// falls back to the type of the file extension.
+func sniffType(head []byte, name string) string {
+
+	sniffed := http.DetectContentType(head)
+
+	if sniffed == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(filepath.Ext(name)); byExt != "" {
+			return byExt
+		}
+	}
+
+	return sniffed
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCreateAssetInsertFailed(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "assets-")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	local, err := storage.NewLocalStore(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	// upload posts a text file to the handler and returns the status
+	upload := func(mt *mtest.T, s *AssetHandler) int {
+
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+
+		part, err := form.CreateFormFile("file", "notes.txt")
+
+		if err != nil {
+			mt.Fatal(err)
+		}
+
+		part.Write([]byte("notes"))
+		form.Close()
+
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.POST("/assets", s.createAssetHandler)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/assets", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		r.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	mt.Run("file removed", func(mt *mtest.T) {
+
+		s := &AssetHandler{DB: mt.DB, Collection: "files", Prefix: "assets", Stores: map[string]storage.Store{"local": local}, Storage: "local"}
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.files", mtest.FirstBatch),
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 2, Message: "insert failed"}),
+			mtest.CreateCursorResponse(0, "test.files", mtest.FirstBatch),
+		)
+
+		if code := upload(mt, s); code != http.StatusInternalServerError {
+			mt.Fatalf("status = %v, want 500", code)
+		}
+
+		files, _ := ioutil.ReadDir(dir + "/assets")
+
+		if len(files) != 0 {
+			mt.Errorf("file of the failed insert is kept: %v", files[0].Name())
+		}
+	})
+
+	mt.Run("file of an existing asset kept", func(mt *mtest.T) {
+
+		s := &AssetHandler{DB: mt.DB, Collection: "files", Prefix: "assets", Stores: map[string]storage.Store{"local": local}, Storage: "local"}
+
+		// the insert was written after all
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.files", mtest.FirstBatch),
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 2, Message: "insert failed"}),
+			mtest.CreateCursorResponse(0, "test.files", mtest.FirstBatch, bson.D{{Key: "_id", Value: primitive.NewObjectID()}}),
+		)
+
+		if code := upload(mt, s); code != http.StatusInternalServerError {
+			mt.Fatalf("status = %v, want 500", code)
+		}
+
+		files, _ := ioutil.ReadDir(dir + "/assets")
+
+		if len(files) != 1 {
+			mt.Errorf("files = %v, want the file of the asset", len(files))
+		}
+	})
+}
@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/audit"
+	"github.com/lexffe/backend.lexffe.io/auth"
+	"github.com/lexffe/backend.lexffe.io/helpers"
+	"github.com/lexffe/backend.lexffe.io/models"
+	"github.com/xeipuuv/gojsonschema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CustomHandler is a helper struct for all handlers of collections with a user-defined schema.
+type CustomHandler struct {
+	Router     *gin.RouterGroup
+	DB         *mongo.Database
+	Collection string
+	Audit      *audit.Log
+
+	// Settings are the settings of the collection, from its meta document.
+	Settings models.CollectionSettings
+
+	// Schema validates the documents of the collection, see CompileSchema.
+	Schema *gojsonschema.Schema
+}
+
+// CompileSchema compiles a JSON Schema. Only local references ("#...") are allowed, remote schemas are not
+// fetched.
+func CompileSchema(schema models.Schema) (*gojsonschema.Schema, error) {
+
+	var v interface{}
+
+	if err := json.Unmarshal(schema, &v); err != nil {
+		return nil, err
+	}
+
+	if !localRefs(v) {
+		return nil, errors.New("schema can only have local references")
+	}
+
+	return gojsonschema.NewSchema(gojsonschema.NewGoLoader(v))
+}
+
+// localRefs reports whether every $ref in the schema is a local reference.
+func localRefs(v interface{}) bool {
+
+	switch v := v.(type) {
+
+	case map[string]interface{}:
+		for k, child := range v {
+			if ref, ok := child.(string); ok && k == "$ref" && !strings.HasPrefix(ref, "#") {
+				return false
+			}
+			if !localRefs(child) {
+				return false
+			}
+		}
+
+	case []interface{}:
+		for _, child := range v {
+			if !localRefs(child) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// RegisterRoutes sets the router routes.
+func (s *CustomHandler) RegisterRoutes() {
+	reads := s.Router.Group("/")
+
+	// private collections can only be read with the read scope
+	if s.Settings.Private {
+		reads.Use(auth.CheckAuthentication("read:" + s.Collection))
+	}
+
+	reads.GET("/", s.getDocumentsHandler)
+	reads.GET("/:id", s.getDocumentHandler)
+
+	protected := s.Router.Group("/", auth.CheckAuthentication("write:"+s.Collection))
+
+	protected.POST("/", s.createDocumentHandler)
+	protected.PUT("/:id", s.updateDocumentHandler)
+	protected.DELETE("/:id", s.deleteDocumentHandler)
+}
+
+// EnsureIndexes creates the indexes of the collection.
+func (s *CustomHandler) EnsureIndexes(ctx context.Context) error {
+
+	indexes := []mongo.IndexModel{
+		// listing of published documents, newest first
+		{Keys: bson.D{{Key: "published", Value: 1}, {Key: "_id", Value: -1}}},
+	}
+
+	return ensureIndexes(ctx, s.DB.Collection(s.Collection), append(indexes, sortIndexes(s.Settings)...))
+}
+
+// directory
+func (s *CustomHandler) getDocumentsHandler(ctx *gin.Context) {
+
+	// user-defined skip, for pagination.
+	skip, err := strconv.ParseInt(ctx.DefaultQuery("skip", "0"), 10, 64)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("skip is not a number"))
+		ctx.Error(err)
+		return
+	}
+
+	// user-defined limit, for pagination
+	limit, err := strconv.ParseInt(ctx.DefaultQuery("limit", "0"), 10, 64)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("limit is not a number"))
+		ctx.Error(err)
+		return
+	}
+
+	// get length of the collection (for pagination)
+
+	count, err := s.DB.Collection(s.Collection).CountDocuments(ctx.Request.Context(), bson.M{})
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot count number of documents in collection"))
+		ctx.Error(err)
+		return
+	}
+
+	ctx.Header("X-Collection-Length", strconv.FormatInt(count, 10))
+
+	opts := options.Find().
+		SetLimit(s.Settings.Limit(limit)).
+		SetSkip(skip).
+		SetSort(s.Settings.SortOrder())
+
+	cur, err := s.DB.Collection(s.Collection).Find(ctx.Request.Context(), s.visible(ctx), opts)
+
+	// mongo related error
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	results := []bson.M{}
+
+	if err := cur.All(ctx.Request.Context(), &results); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, results)
+}
+
+func (s *CustomHandler) getDocumentHandler(ctx *gin.Context) {
+
+	// get the document identifier (must be _id)
+	objID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid document identifier"))
+		ctx.Error(err)
+		return
+	}
+
+	filter := s.visible(ctx)
+	filter["_id"] = objID
+
+	res := s.DB.Collection(s.Collection).FindOne(ctx.Request.Context(), filter)
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.Status(http.StatusInternalServerError)
+			ctx.Error(res.Err())
+		}
+		return
+	}
+
+	var doc bson.M
+
+	if err := res.Decode(&doc); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, doc)
+}
+
+func (s *CustomHandler) createDocumentHandler(ctx *gin.Context) {
+
+	// parse body, any shape allowed by the schema. the identifier is generated.
+
+	body, ok := s.bindDocument(ctx)
+
+	if !ok {
+		return
+	}
+
+	if _, ok := body["_id"]; ok {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("document identifier is generated"))
+		return
+	}
+
+	if !s.validate(ctx, body) {
+		return
+	}
+
+	// database operation
+
+	res, err := s.DB.Collection(s.Collection).InsertOne(ctx.Request.Context(), body)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot insert document"))
+		ctx.Error(err)
+		return
+	}
+
+	objID := res.InsertedID.(primitive.ObjectID)
+	body["_id"] = objID
+
+	s.Audit.Record(ctx, audit.ActionCreate, s.Collection, objID, nil, body)
+
+	ctx.JSON(http.StatusCreated, body)
+}
+
+func (s *CustomHandler) updateDocumentHandler(ctx *gin.Context) {
+
+	// get the document identifier (must be _id)
+	objID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid document identifier"))
+		ctx.Error(err)
+		return
+	}
+
+	body, ok := s.bindDocument(ctx)
+
+	if !ok {
+		return
+	}
+
+	// the identifier in the body is optional, but has to match
+
+	if id, ok := body["_id"]; ok {
+
+		if id != objID.Hex() {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("document identifier is different than id in path"))
+			return
+		}
+
+		delete(body, "_id")
+	}
+
+	if !s.validate(ctx, body) {
+		return
+	}
+
+	// replace the whole document, keeping the previous version for the audit log
+
+	res := s.DB.Collection(s.Collection).FindOneAndReplace(ctx.Request.Context(), bson.M{"_id": objID}, body)
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot update document"))
+			ctx.Error(res.Err())
+		}
+		return
+	}
+
+	var before bson.M
+
+	if err := res.Decode(&before); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	body["_id"] = objID
+
+	s.Audit.Record(ctx, audit.ActionUpdate, s.Collection, objID, before, body)
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (s *CustomHandler) deleteDocumentHandler(ctx *gin.Context) {
+
+	// get the document identifier (must be _id)
+	objID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid document identifier"))
+		ctx.Error(err)
+		return
+	}
+
+	res := s.DB.Collection(s.Collection).FindOneAndDelete(ctx.Request.Context(), bson.M{"_id": objID})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.Status(http.StatusInternalServerError)
+		}
+		ctx.Error(res.Err())
+		return
+	}
+
+	var before bson.M
+
+	if err := res.Decode(&before); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	s.Audit.Record(ctx, audit.ActionDelete, s.Collection, objID, before, nil)
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// visible is the filter of the documents the request can read. Documents with "published": false are drafts.
+func (s *CustomHandler) visible(ctx *gin.Context) bson.M {
+
+	if auth.HasScope(ctx, "read:drafts") {
+		return bson.M{}
+	}
+
+	return bson.M{"published": bson.M{"$ne": false}}
+}
+
+// bindDocument parses a JSON object from the body, or responds with an error.
+func (s *CustomHandler) bindDocument(ctx *gin.Context) (bson.M, bool) {
+
+	var body map[string]interface{}
+
+	if err := json.NewDecoder(ctx.Request.Body).Decode(&body); err != nil || body == nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed request body, expected an object"))
+		if err != nil {
+			ctx.Error(err)
+		}
+		return nil, false
+	}
+
+	// field names starting with $ are operators in mongo
+
+	if k, ok := helpers.OperatorField(body); ok {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid field name: "+k))
+		return nil, false
+	}
+
+	return body, true
+}
+
+// validate checks the document against the schema of the collection, or responds with the violations.
+func (s *CustomHandler) validate(ctx *gin.Context, doc bson.M) bool {
+
+	res, err := s.Schema.Validate(gojsonschema.NewGoLoader(map[string]interface{}(doc)))
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("cannot validate document"))
+		ctx.Error(err)
+		return false
+	}
+
+	if res.Valid() {
+		return true
+	}
+
+	violations := []string{}
+
+	for _, e := range res.Errors() {
+		violations = append(violations, e.String())
+	}
+
+	ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+		"error":      "document does not match the schema of the collection",
+		"violations": violations,
+	})
+
+	return false
+}
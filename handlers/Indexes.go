@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/lexffe/backend.lexffe.io/models"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ensureIndexes creates the indexes one by one, so that an index that cannot be created (e.g. a unique index on
+// a collection with duplicates) does not prevent the others. Existing indexes are left as they are. The first
+// error is returned.
+func ensureIndexes(ctx context.Context, coll *mongo.Collection, indexes []mongo.IndexModel) error {
+
+	var first error
+
+	for _, index := range indexes {
+		if _, err := coll.Indexes().CreateOne(ctx, index); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// sortIndexes returns the index of the listing order of the collection, if it is not the default (_id).
+func sortIndexes(settings models.CollectionSettings) []mongo.IndexModel {
+
+	sort := settings.SortOrder()
+
+	if len(sort) == 1 {
+		return nil
+	}
+
+	return []mongo.IndexModel{{Keys: sort}}
+}
@@ -1,12 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/audit"
 	"github.com/lexffe/backend.lexffe.io/auth"
 	"github.com/lexffe/backend.lexffe.io/helpers"
 	"github.com/lexffe/backend.lexffe.io/models"
@@ -22,18 +24,64 @@ type PageHandler struct {
 	DB         *mongo.Database
 	PageType   models.ObjectType
 	Collection string
+	Audit      *audit.Log
+
+	// Settings are the settings of the collection, from its meta document.
+	Settings models.CollectionSettings
 }
 
 // RegisterRoutes sets the router routes.
 func (s *PageHandler) RegisterRoutes() {
-	s.Router.GET("/", s.getPagesHandler)
-	s.Router.GET("/:id", s.getPageHandler)
+	reads := s.Router.Group("/")
+
+	// private collections can only be read with the read scope
+	if s.Settings.Private {
+		reads.Use(auth.CheckAuthentication("read:" + s.Collection))
+	}
+
+	reads.GET("/", s.getPagesHandler)
+	reads.GET("/:id", s.getPageHandler)
 
-	protected := s.Router.Group("/", auth.CheckAuthentication)
+	protected := s.Router.Group("/", auth.CheckAuthentication("write:"+s.Collection))
 
 	protected.POST("/", s.createPageHandler)
 	protected.PUT("/:id", s.updatePageHandler)
+	protected.PATCH("/:id", s.patchPageHandler)
 	protected.DELETE("/:id", s.deletePageHandler)
+
+	// revisions contain drafts, they are only read by writers
+	protected.GET("/:id/revisions", s.getRevisionsHandler)
+	protected.GET("/:id/revisions/:rev", s.getRevisionHandler)
+	protected.POST("/:id/revisions/:rev/restore", s.restoreRevisionHandler)
+	protected.GET("/:id/diff", s.getDiffHandler)
+}
+
+// EnsureIndexes creates the indexes of the collection.
+func (s *PageHandler) EnsureIndexes(ctx context.Context) error {
+
+	indexes := []mongo.IndexModel{
+		// lookup by title, titles are unique
+		{Keys: bson.M{"searchable_title": 1}, Options: options.Index().SetUnique(true)},
+
+		// redirection of previous titles
+		{Keys: bson.M{"previous_slugs": 1}},
+
+		// listing of published pages, newest first
+		{Keys: bson.D{{Key: "published", Value: 1}, {Key: "_id", Value: -1}}},
+
+		// filtering by tag
+		{Keys: bson.M{"tags": 1}},
+
+		// scheduled publishing, see PublishScheduled
+		{Keys: bson.M{"publish_at": 1}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.M{"unpublish_at": 1}, Options: options.Index().SetSparse(true)},
+	}
+
+	if err := ensureIndexes(ctx, s.DB.Collection(s.Collection), append(indexes, sortIndexes(s.Settings)...)); err != nil {
+		return err
+	}
+
+	return ensureIndexes(ctx, s.DB.Collection(RevisionCollection), revisionIndexes)
 }
 
 // directory
@@ -84,10 +132,8 @@ func (s *PageHandler) getPagesHandler(ctx *gin.Context) {
 
 	ctx.Header("X-Collection-Length", strconv.FormatInt(count, 10))
 
-	// only get the published pages
-	filter := bson.M{
-		"published": true,
-	}
+	// only get the published pages, at the time of the request
+	filter := publishedAt(time.Now())
 
 	projection := bson.M{
 		"title":            true,
@@ -99,24 +145,25 @@ func (s *PageHandler) getPagesHandler(ctx *gin.Context) {
 		"published":        true,
 		"last_updated":     true,
 		"updated":          true,
+		"version":          true,
 	}
 
 	if simple == true {
 		delete(projection, "html")
 	}
 
-	// if user is authenticated, get the drafts as well. i.e. no filter
-	if ctx.MustGet("Authorized").(bool) == true {
-		delete(filter, "published")
+	// if user can read drafts, get the drafts as well (and their schedule). i.e. no filter
+	if auth.HasScope(ctx, "read:drafts") {
+		filter = bson.M{}
+		projection["publish_at"] = true
+		projection["unpublish_at"] = true
 	}
 
 	opts := options.Find().
-		SetLimit(limit).
+		SetLimit(s.Settings.Limit(limit)).
 		SetSkip(skip).
 		SetProjection(projection).
-		SetSort(bson.M{
-			"_id": -1,
-		})
+		SetSort(s.Settings.SortOrder())
 
 	cur, err := s.DB.Collection(s.Collection).Find(ctx.Request.Context(), filter, opts)
 	//noinspection GoNilness
@@ -157,8 +204,8 @@ func (s *PageHandler) getPageHandler(ctx *gin.Context) {
 		return
 	}
 
-	// only get the published pages
-	filter := bson.M{"published": true}
+	// only get the published pages, at the time of the request
+	filter := publishedAt(time.Now())
 
 	if isObjID {
 		objID, err := primitive.ObjectIDFromHex(docID)
@@ -182,11 +229,13 @@ func (s *PageHandler) getPageHandler(ctx *gin.Context) {
 		"published":        true,
 		"last_updated":     true,
 		"updated":          true,
+		"version":          true,
 	})
 
-	// if user is authenticated, get the drafts as well. i.e. no filter
-	if ctx.MustGet("Authorized").(bool) == true {
-		delete(filter, "published")
+	// if user can read drafts, get the drafts as well. i.e. no filter
+	if auth.HasScope(ctx, "read:drafts") {
+		delete(filter, "$or")
+		delete(filter, "unpublish_at")
 		// unset projection
 		opts.SetProjection(bson.M{})
 	}
@@ -196,11 +245,12 @@ func (s *PageHandler) getPageHandler(ctx *gin.Context) {
 	res := s.DB.Collection(s.Collection).FindOne(ctx.Request.Context(), filter, opts)
 
 	if res.Err() != nil {
-		if res.Err() == mongo.ErrNoDocuments {
-			ctx.Status(http.StatusNotFound)
-		} else {
+		if res.Err() != mongo.ErrNoDocuments {
 			ctx.Status(http.StatusInternalServerError)
 			ctx.Error(res.Err())
+		} else if isObjID || !s.redirectSlug(ctx, filter, docID) {
+			// not a previous title either
+			ctx.Status(http.StatusNotFound)
 		}
 
 		return
@@ -217,6 +267,7 @@ func (s *PageHandler) getPageHandler(ctx *gin.Context) {
 
 	// return
 
+	ctx.Header("ETag", etag(doc.Version))
 	ctx.JSON(http.StatusOK, doc)
 }
 
@@ -233,34 +284,30 @@ func (s *PageHandler) createPageHandler(ctx *gin.Context) {
 		return
 	}
 
-	// generated fields: { searchable_title }
+	if err := body.CheckSchedule(); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
 
-	stitle, err := helpers.ParseKebab(body.Title)
-	if err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot generate searchable title"))
-		ctx.Error(err)
+	if err := body.CheckSlug(); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
-	body.SearchableTitle = stitle
 
-	n, err := s.DB.Collection(s.Collection).CountDocuments(ctx.Request.Context(), bson.M{
-		"searchable_title": stitle,
-	})
+	// generated fields: { searchable_title }, from the slug if set
 
+	stitle, err := SearchableTitle(body)
 	if err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, errors.New("check existing document failed"))
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot generate searchable title"))
 		ctx.Error(err)
 		return
 	}
-
-	if n > 0 {
-		ctx.AbortWithError(http.StatusConflict, errors.New("page with same title exists"))
-		return
-	}
+	body.SearchableTitle = stitle
+	body.PreviousSlugs = nil
 
 	// generated fields: { page_type, html, last_updated }
 
-	html, err := helpers.ParseMD(body.Markdown)
+	html, err := helpers.ParseMDProfile(body.Markdown, s.Settings.Markdown)
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot generate html from markdown"))
 		ctx.Error(err)
@@ -272,17 +319,38 @@ func (s *PageHandler) createPageHandler(ctx *gin.Context) {
 	body.PageType = s.PageType
 	body.LastUpdated = time.Now()
 	body.Updated = false
+	body.Version = 1
 
 	// database operation
 
-	_, err = s.DB.Collection(s.Collection).InsertOne(ctx.Request.Context(), body)
+	// titles are unique (index on searchable_title)
+
+	res, err := s.DB.Collection(s.Collection).InsertOne(ctx.Request.Context(), body)
 
 	if err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot insert document"))
+		if helpers.IsDuplicateKey(err) {
+			ctx.AbortWithError(http.StatusConflict, errors.New("page with same title exists"))
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot insert document"))
+		}
+		ctx.Error(err)
+		return
+	}
+
+	body.ObjectID = res.InsertedID.(primitive.ObjectID)
+
+	s.Audit.Record(ctx, audit.ActionCreate, s.Collection, body.ObjectID, nil, body)
+
+	// the first revision
+
+	if _, err := s.saveRevision(ctx, body, 0); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("page created, but revision cannot be saved"))
 		ctx.Error(err)
 		return
 	}
 
+	ctx.Header("ETag", etag(body.Version))
+
 	// ok, return
 	ctx.Status(http.StatusCreated)
 }
@@ -307,22 +375,43 @@ func (s *PageHandler) updatePageHandler(ctx *gin.Context) {
 		return
 	}
 
-	if body.ObjectID.String() != docID {
+	if body.ObjectID.Hex() != docID {
 		ctx.AbortWithError(http.StatusBadRequest, errors.New("document identifier is different than id in path"))
 		return
 	}
 
-	// generated fields, in case of new title / edited markdown: { searchable_title, html, last_updated }
+	// the page has to be at the version of If-Match
+
+	current, ok := s.currentPage(ctx, body.ObjectID)
+
+	if !ok {
+		return
+	}
+
+	version := current.Version
+
+	if err := body.CheckSchedule(); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if err := body.CheckSlug(); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	// generated fields, in case of new title / edited markdown: { searchable_title, previous_slugs, html, last_updated }
 
-	stitle, err := helpers.ParseKebab(body.Title)
+	stitle, err := SearchableTitle(body)
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot generate searchable title"))
 		ctx.Error(err)
 		return
 	}
 	body.SearchableTitle = stitle
+	body.PreviousSlugs = slugHistory(current.PreviousSlugs, current.SearchableTitle, stitle)
 
-	html, err := helpers.ParseMD(body.Markdown)
+	html, err := helpers.ParseMDProfile(body.Markdown, s.Settings.Markdown)
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot generate html from markdown"))
 		ctx.Error(err)
@@ -330,26 +419,49 @@ func (s *PageHandler) updatePageHandler(ctx *gin.Context) {
 	}
 	body.HTML = html
 
+	body.PageType = s.PageType
 	body.LastUpdated = time.Now()
 	body.Updated = true
+	body.Version = version + 1
 
 	filter := bson.M{
-		"_id": body.ObjectID,
+		"_id":     body.ObjectID,
+		"version": VersionFilter(version),
 	}
 
-	res, err := s.DB.Collection(s.Collection).UpdateOne(ctx.Request.Context(), filter, body)
+	// replace the whole document, keeping the previous version for the audit log
 
-	if err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot update document"))
-		ctx.Error(err)
+	res := s.DB.Collection(s.Collection).FindOneAndReplace(ctx.Request.Context(), filter, body)
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			modifiedConcurrently(ctx)
+		} else if helpers.IsDuplicateKey(res.Err()) {
+			ctx.AbortWithError(http.StatusConflict, errors.New("page with same title exists"))
+			ctx.Error(res.Err())
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot update document"))
+			ctx.Error(res.Err())
+		}
+		return
+	}
+
+	var before bson.M
+
+	if err := res.Decode(&before); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
-	if res.MatchedCount == 0 {
-		ctx.Status(http.StatusNotFound)
+	s.Audit.Record(ctx, audit.ActionUpdate, s.Collection, body.ObjectID, before, body)
+
+	// pages saved before revisions were kept get the previous version as their first revision
+
+	if !s.saveRevisions(ctx, res, body) {
 		return
 	}
 
+	ctx.Header("ETag", etag(body.Version))
 	ctx.Status(http.StatusNoContent)
 }
 
@@ -371,15 +483,24 @@ func (s *PageHandler) deletePageHandler(ctx *gin.Context) {
 		return
 	}
 
+	// the page has to be at the version of If-Match
+
+	version, ok := currentVersion(ctx, s.DB.Collection(s.Collection), objID, s.Settings.RequireIfMatch)
+
+	if !ok {
+		return
+	}
+
 	filter := bson.M{
-		"_id": objID,
+		"_id":     objID,
+		"version": VersionFilter(version),
 	}
 
 	res := s.DB.Collection(s.Collection).FindOneAndDelete(ctx.Request.Context(), filter)
 
 	if res.Err() != nil {
 		if res.Err() == mongo.ErrNoDocuments {
-			ctx.Status(http.StatusNotFound)
+			modifiedConcurrently(ctx)
 		} else {
 			ctx.Status(http.StatusInternalServerError)
 		}
@@ -387,5 +508,59 @@ func (s *PageHandler) deletePageHandler(ctx *gin.Context) {
 		return
 	}
 
+	var before bson.M
+	var page models.Page
+
+	if err := res.Decode(&before); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := res.Decode(&page); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	s.Audit.Record(ctx, audit.ActionDelete, s.Collection, objID, before, nil)
+
+	// the final version is kept, so that a restored page continues from it (see restoreRevisionHandler)
+
+	latest, err := s.latestVersion(ctx.Request.Context(), objID)
+
+	if err == nil && latest < page.Version {
+		_, err = s.saveRevision(ctx, page, 0)
+	}
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("page deleted, but revision cannot be saved"))
+		ctx.Error(err)
+		return
+	}
+
 	ctx.Status(http.StatusNoContent)
 }
+
+// saveRevisions saves the new version of an updated page, and the previous version if the page has no revisions.
+func (s *PageHandler) saveRevisions(ctx *gin.Context, res *mongo.SingleResult, page models.Page) bool {
+
+	var previous models.Page
+	var author string
+
+	if id, ok := auth.GetIdentity(ctx); ok {
+		author = id.User
+	}
+
+	err := res.Decode(&previous)
+
+	if err == nil {
+		err = SaveRevisions(s.DB, s.Collection, &previous, page, author)
+	}
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("page updated, but revision cannot be saved"))
+		ctx.Error(err)
+		return false
+	}
+
+	return true
+}
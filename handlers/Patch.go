@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/audit"
+	"github.com/lexffe/backend.lexffe.io/helpers"
+	"github.com/lexffe/backend.lexffe.io/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Content types of the bodies of PATCH requests.
+const (
+	mergePatchType = "application/merge-patch+json" // RFC 7396
+	jsonPatchType  = "application/json-patch+json"  // RFC 6902
+)
+
+// patchDocument applies the patch in the body of the request to the JSON representation of doc, and decodes the
+// result into patched. It responds with an error if the patch is malformed or cannot be applied.
+func patchDocument(ctx *gin.Context, doc interface{}, patched interface{}) bool {
+
+	original, err := json.Marshal(doc)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return false
+	}
+
+	body, err := ioutil.ReadAll(ctx.Request.Body)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("cannot read request body"))
+		ctx.Error(err)
+		return false
+	}
+
+	var result []byte
+
+	switch ctx.ContentType() {
+
+	case mergePatchType:
+		if !json.Valid(body) {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed merge patch"))
+			return false
+		}
+
+		result, err = jsonpatch.MergePatch(original, body)
+
+	case jsonPatchType:
+		var patch jsonpatch.Patch
+
+		if patch, err = jsonpatch.DecodePatch(body); err != nil {
+			ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed json patch"))
+			ctx.Error(err)
+			return false
+		}
+
+		result, err = patch.Apply(original)
+
+	default:
+		ctx.AbortWithError(http.StatusUnsupportedMediaType, errors.New("content type should be "+mergePatchType+" or "+jsonPatchType))
+		return false
+	}
+
+	// e.g. a failed test operation, or a path that does not exist
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusUnprocessableEntity, errors.New("patch cannot be applied"))
+		ctx.Error(err)
+		return false
+	}
+
+	if err := json.Unmarshal(result, patched); err != nil {
+		ctx.AbortWithError(http.StatusUnprocessableEntity, errors.New("patched document is malformed"))
+		ctx.Error(err)
+		return false
+	}
+
+	return true
+}
+
+// patchPageHandler partially updates a page. Generated fields are only generated again if their inputs change.
+func (s *PageHandler) patchPageHandler(ctx *gin.Context) {
+
+	objID, ok := pageID(ctx)
+
+	if !ok {
+		return
+	}
+
+	res := s.DB.Collection(s.Collection).FindOne(ctx.Request.Context(), bson.M{"_id": objID})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.Status(http.StatusInternalServerError)
+			ctx.Error(res.Err())
+		}
+		return
+	}
+
+	var current models.Page
+
+	if err := res.Decode(&current); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// the page has to be at the version of If-Match
+
+	if !ifMatch(ctx, current.Version, s.Settings.RequireIfMatch) {
+		return
+	}
+
+	var body models.Page
+
+	if !patchDocument(ctx, current, &body) {
+		return
+	}
+
+	if body.ObjectID != objID {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("document identifier cannot be changed"))
+		return
+	}
+
+	// required fields, as in createPageHandler
+
+	if body.Title == "" || body.Subtitle == "" || body.Markdown == "" || body.Tags == nil {
+		ctx.AbortWithError(http.StatusUnprocessableEntity, errors.New("title, subtitle, markdown and tags are required"))
+		return
+	}
+
+	if err := body.CheckSchedule(); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if err := body.CheckSlug(); err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	// generated fields: { searchable_title, previous_slugs } if the title or slug changed, { html } if the markdown
+	// changed
+
+	body.SearchableTitle = current.SearchableTitle
+
+	if body.Title != current.Title || body.Slug != current.Slug {
+		stitle, err := SearchableTitle(body)
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot generate searchable title"))
+			ctx.Error(err)
+			return
+		}
+		body.SearchableTitle = stitle
+	}
+
+	body.PreviousSlugs = slugHistory(current.PreviousSlugs, current.SearchableTitle, body.SearchableTitle)
+
+	body.HTML = current.HTML
+
+	if body.Markdown != current.Markdown {
+		html, err := helpers.ParseMDProfile(body.Markdown, s.Settings.Markdown)
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot generate html from markdown"))
+			ctx.Error(err)
+			return
+		}
+		body.HTML = html
+	}
+
+	body.PageType = s.PageType
+	body.LastUpdated = time.Now()
+	body.Updated = true
+	body.Version = current.Version + 1
+
+	// replace the whole document, keeping the previous version for the audit log
+
+	filter := bson.M{"_id": objID, "version": VersionFilter(current.Version)}
+
+	replaced := s.DB.Collection(s.Collection).FindOneAndReplace(ctx.Request.Context(), filter, body)
+
+	if replaced.Err() != nil {
+		if replaced.Err() == mongo.ErrNoDocuments {
+			modifiedConcurrently(ctx)
+		} else if helpers.IsDuplicateKey(replaced.Err()) {
+			ctx.AbortWithError(http.StatusConflict, errors.New("page with same title exists"))
+			ctx.Error(replaced.Err())
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot update document"))
+			ctx.Error(replaced.Err())
+		}
+		return
+	}
+
+	var before bson.M
+
+	if err := replaced.Decode(&before); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	s.Audit.Record(ctx, audit.ActionUpdate, s.Collection, objID, before, body)
+
+	if !s.saveRevisions(ctx, replaced, body) {
+		return
+	}
+
+	ctx.Header("ETag", etag(body.Version))
+	ctx.JSON(http.StatusOK, body)
+}
+
+// patchReferenceHandler partially updates a reference.
+func (s *ReferenceHandler) patchReferenceHandler(ctx *gin.Context) {
+
+	objID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid document identifier"))
+		ctx.Error(err)
+		return
+	}
+
+	res := s.DB.Collection(s.Collection).FindOne(ctx.Request.Context(), bson.M{"_id": objID})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.Status(http.StatusInternalServerError)
+			ctx.Error(res.Err())
+		}
+		return
+	}
+
+	var current models.Reference
+
+	if err := res.Decode(&current); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// the reference has to be at the version of If-Match
+
+	if !ifMatch(ctx, current.Version, s.Settings.RequireIfMatch) {
+		return
+	}
+
+	var body models.Reference
+
+	if !patchDocument(ctx, current, &body) {
+		return
+	}
+
+	if body.ObjectID != objID {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("document identifier cannot be changed"))
+		return
+	}
+
+	// required fields, as in createReferenceHandler
+
+	if body.Name == "" || body.Description == "" || body.ReferenceSource == "" {
+		ctx.AbortWithError(http.StatusUnprocessableEntity, errors.New("name, description and reference_source are required"))
+		return
+	}
+
+	body.ReferenceType = s.ReferenceType
+	body.Version = current.Version + 1
+
+	// replace the whole document, keeping the previous version for the audit log
+
+	filter := bson.M{"_id": objID, "version": VersionFilter(current.Version)}
+
+	replaced := s.DB.Collection(s.Collection).FindOneAndReplace(ctx.Request.Context(), filter, body)
+
+	if replaced.Err() != nil {
+		if replaced.Err() == mongo.ErrNoDocuments {
+			modifiedConcurrently(ctx)
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot update document"))
+			ctx.Error(replaced.Err())
+		}
+		return
+	}
+
+	var before bson.M
+
+	if err := replaced.Decode(&before); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	s.Audit.Record(ctx, audit.ActionUpdate, s.Collection, objID, before, body)
+
+	ctx.Header("ETag", etag(body.Version))
+	ctx.JSON(http.StatusOK, body)
+}
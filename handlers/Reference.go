@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/audit"
 	"github.com/lexffe/backend.lexffe.io/auth"
 	"github.com/lexffe/backend.lexffe.io/models"
 	"go.mongodb.org/mongo-driver/bson"
@@ -20,20 +22,37 @@ type ReferenceHandler struct {
 	DB            *mongo.Database
 	ReferenceType models.ObjectType
 	Collection    string
+	Audit         *audit.Log
+
+	// Settings are the settings of the collection, from its meta document.
+	Settings models.CollectionSettings
 }
 
 // RegisterRoutes sets the router routes.
 func (s *ReferenceHandler) RegisterRoutes() {
-	s.Router.GET("/", s.getReferencesHandler)
-	s.Router.GET("/:id", s.getReferenceHandler)
+	reads := s.Router.Group("/")
+
+	// private collections can only be read with the read scope
+	if s.Settings.Private {
+		reads.Use(auth.CheckAuthentication("read:" + s.Collection))
+	}
 
-	protected := s.Router.Group("/", auth.CheckAuthentication)
+	reads.GET("/", s.getReferencesHandler)
+	reads.GET("/:id", s.getReferenceHandler)
+
+	protected := s.Router.Group("/", auth.CheckAuthentication("write:"+s.Collection))
 
 	protected.POST("/", s.createReferenceHandler)
 	protected.PUT("/:id", s.updateReferenceHandler)
+	protected.PATCH("/:id", s.patchReferenceHandler)
 	protected.DELETE("/:id", s.deleteReferenceHandler)
 }
 
+// EnsureIndexes creates the indexes of the collection.
+func (s *ReferenceHandler) EnsureIndexes(ctx context.Context) error {
+	return ensureIndexes(ctx, s.DB.Collection(s.Collection), sortIndexes(s.Settings))
+}
+
 func (s *ReferenceHandler) getReferencesHandler(ctx *gin.Context) {
 
 	// user-defined skip, for pagination.
@@ -70,11 +89,9 @@ func (s *ReferenceHandler) getReferencesHandler(ctx *gin.Context) {
 	ctx.Header("X-Collection-Length", strconv.FormatInt(count, 10))
 
 	opts := options.Find().
-		SetLimit(limit).
+		SetLimit(s.Settings.Limit(limit)).
 		SetSkip(skip).
-		SetSort(bson.M{
-			"_id": -1,
-		})
+		SetSort(s.Settings.SortOrder())
 		// .SetProjection
 
 	cur, err := s.DB.Collection(s.Collection).Find(ctx.Request.Context(), bson.M{}, opts)
@@ -141,6 +158,7 @@ func (s *ReferenceHandler) getReferenceHandler(ctx *gin.Context) {
 	}
 
 	// return
+	ctx.Header("ETag", etag(doc.Version))
 	ctx.JSON(http.StatusOK, doc)
 }
 
@@ -150,11 +168,14 @@ func (s *ReferenceHandler) createReferenceHandler(ctx *gin.Context) {
 
 	if err := ctx.BindJSON(&body); err != nil {
 		ctx.AbortWithError(http.StatusBadRequest, errors.New("malformed request body"))
+		ctx.Error(err)
+		return
 	}
 
 	body.ReferenceType = s.ReferenceType
+	body.Version = 1
 
-	_, err := s.DB.Collection(s.Collection).InsertOne(ctx.Request.Context(), body)
+	res, err := s.DB.Collection(s.Collection).InsertOne(ctx.Request.Context(), body)
 
 	if err != nil {
 		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot insert document"))
@@ -162,6 +183,11 @@ func (s *ReferenceHandler) createReferenceHandler(ctx *gin.Context) {
 		return
 	}
 
+	body.ObjectID = res.InsertedID.(primitive.ObjectID)
+
+	s.Audit.Record(ctx, audit.ActionCreate, s.Collection, body.ObjectID, nil, body)
+
+	ctx.Header("ETag", etag(body.Version))
 	ctx.Status(http.StatusCreated)
 }
 
@@ -189,28 +215,51 @@ func (s *ReferenceHandler) updateReferenceHandler(ctx *gin.Context) {
 		return
 	}
 
-	if body.ObjectID.String() != docID {
+	if body.ObjectID.Hex() != docID {
 		ctx.AbortWithError(http.StatusBadRequest, errors.New("document identifier is different than id in path"))
 		return
 	}
 
+	// the reference has to be at the version of If-Match
+
+	version, ok := currentVersion(ctx, s.DB.Collection(s.Collection), objID, s.Settings.RequireIfMatch)
+
+	if !ok {
+		return
+	}
+
+	body.ReferenceType = s.ReferenceType
+	body.Version = version + 1
+
 	filter := bson.M{
-		"_id": objID,
+		"_id":     objID,
+		"version": VersionFilter(version),
 	}
 
-	res, err := s.DB.Collection(s.Collection).UpdateOne(ctx.Request.Context(), filter, body)
+	// replace the whole document, keeping the previous version for the audit log
 
-	if err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot update document"))
-		ctx.Error(err)
+	res := s.DB.Collection(s.Collection).FindOneAndReplace(ctx.Request.Context(), filter, body)
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			modifiedConcurrently(ctx)
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot update document"))
+			ctx.Error(res.Err())
+		}
 		return
 	}
 
-	if res.MatchedCount == 0 {
-		ctx.Status(http.StatusNotFound)
+	var before bson.M
+
+	if err := res.Decode(&before); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
+	s.Audit.Record(ctx, audit.ActionUpdate, s.Collection, objID, before, body)
+
+	ctx.Header("ETag", etag(body.Version))
 	ctx.Status(http.StatusNoContent)
 }
 
@@ -230,15 +279,24 @@ func (s *ReferenceHandler) deleteReferenceHandler(ctx *gin.Context) {
 		return
 	}
 
+	// the reference has to be at the version of If-Match
+
+	version, ok := currentVersion(ctx, s.DB.Collection(s.Collection), objID, s.Settings.RequireIfMatch)
+
+	if !ok {
+		return
+	}
+
 	filter := bson.M{
-		"_id": objID,
+		"_id":     objID,
+		"version": VersionFilter(version),
 	}
 
 	res := s.DB.Collection(s.Collection).FindOneAndDelete(ctx.Request.Context(), filter)
 
 	if res.Err() != nil {
 		if res.Err() == mongo.ErrNoDocuments {
-			ctx.Status(http.StatusNotFound)
+			modifiedConcurrently(ctx)
 		} else {
 			ctx.Status(http.StatusInternalServerError)
 		}
@@ -246,5 +304,14 @@ func (s *ReferenceHandler) deleteReferenceHandler(ctx *gin.Context) {
 		return
 	}
 
+	var before bson.M
+
+	if err := res.Decode(&before); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	s.Audit.Record(ctx, audit.ActionDelete, s.Collection, objID, before, nil)
+
 	ctx.Status(http.StatusNoContent)
 }
@@ -0,0 +1,484 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/audit"
+	"github.com/lexffe/backend.lexffe.io/auth"
+	"github.com/lexffe/backend.lexffe.io/helpers"
+	"github.com/lexffe/backend.lexffe.io/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RevisionCollection keeps the saved versions of the pages of every page collection.
+const RevisionCollection = "revisions"
+
+// revisionIndexes are the indexes of the revisions collection. The numbers of the revisions of a page are unique.
+var revisionIndexes = []mongo.IndexModel{
+	{
+		Keys:    bson.D{{Key: "collection", Value: 1}, {Key: "document", Value: 1}, {Key: "rev", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	},
+}
+
+// MoveRevisions moves the revisions of the pages of a collection to another name, e.g. when it is renamed.
+func MoveRevisions(ctx context.Context, db *mongo.Database, from string, to string) error {
+	_, err := db.Collection(RevisionCollection).UpdateMany(ctx, bson.M{"collection": from}, bson.M{"$set": bson.M{"collection": to}})
+	return err
+}
+
+// DeleteRevisions deletes the revisions of the pages of a collection.
+func DeleteRevisions(ctx context.Context, db *mongo.Database, collection string) error {
+	_, err := db.Collection(RevisionCollection).DeleteMany(ctx, bson.M{"collection": collection})
+	return err
+}
+
+// SaveRevisions saves a version of a page of the collection by the author, and the previous version (if any) first
+// if the page has no revisions yet, e.g. pages saved before revisions were kept.
+func SaveRevisions(db *mongo.Database, collection string, previous *models.Page, page models.Page, author string) error {
+
+	// not the request context: the revisions are saved even if the client has gone away
+
+	latest, err := latestRevision(context.Background(), db, collection, page.ObjectID)
+
+	if err != nil {
+		return err
+	}
+
+	if latest == 0 && previous != nil {
+		if _, err := insertRevision(db, models.Revision{Collection: collection, Document: page.ObjectID, Author: author, Page: *previous}); err != nil {
+			return err
+		}
+	}
+
+	_, err = insertRevision(db, models.Revision{Collection: collection, Document: page.ObjectID, Author: author, Page: page})
+
+	return err
+}
+
+// saveRevision saves a version of the page, with the next number.
+func (s *PageHandler) saveRevision(ctx *gin.Context, page models.Page, restored int) (models.Revision, error) {
+
+	rev := models.Revision{
+		Collection: s.Collection,
+		Document:   page.ObjectID,
+		Restored:   restored,
+		Page:       page,
+	}
+
+	if id, ok := auth.GetIdentity(ctx); ok {
+		rev.Author = id.User
+	}
+
+	return insertRevision(s.DB, rev)
+}
+
+// insertRevision saves a revision with the next number of its page. Concurrent saves are retried.
+func insertRevision(db *mongo.Database, rev models.Revision) (models.Revision, error) {
+
+	for attempt := 0; attempt < 3; attempt++ {
+
+		latest, err := latestRevision(context.Background(), db, rev.Collection, rev.Document)
+
+		if err != nil {
+			return rev, err
+		}
+
+		rev.Number = latest + 1
+		rev.Time = time.Now()
+
+		res, err := db.Collection(RevisionCollection).InsertOne(context.Background(), rev)
+
+		if err == nil {
+			rev.ObjectID = res.InsertedID.(primitive.ObjectID)
+			return rev, nil
+		}
+
+		if !helpers.IsDuplicateKey(err) {
+			return rev, err
+		}
+	}
+
+	return rev, errors.New("revision number is taken by concurrent saves")
+}
+
+// latestRevision returns the number of the latest revision of a page, 0 if there is none.
+func latestRevision(ctx context.Context, db *mongo.Database, collection string, objID primitive.ObjectID) (int, error) {
+
+	opts := options.FindOne().
+		SetSort(bson.M{"rev": -1}).
+		SetProjection(bson.M{"rev": true})
+
+	var rev models.Revision
+
+	err := db.Collection(RevisionCollection).FindOne(ctx, bson.M{"collection": collection, "document": objID}, opts).Decode(&rev)
+
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+
+	return rev.Number, err
+}
+
+// latestVersion returns the highest version of a page in its revisions, 0 if there is none.
+func (s *PageHandler) latestVersion(ctx context.Context, objID primitive.ObjectID) (int64, error) {
+
+	opts := options.FindOne().
+		SetSort(bson.M{"page.version": -1}).
+		SetProjection(bson.M{"page.version": true})
+
+	var rev models.Revision
+
+	err := s.DB.Collection(RevisionCollection).FindOne(ctx, bson.M{"collection": s.Collection, "document": objID}, opts).Decode(&rev)
+
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+
+	return rev.Page.Version, err
+}
+
+// findRevision returns a revision of a page, or responds with an error.
+func (s *PageHandler) findRevision(ctx *gin.Context, objID primitive.ObjectID, number int) (models.Revision, bool) {
+
+	var rev models.Revision
+
+	res := s.DB.Collection(RevisionCollection).FindOne(ctx.Request.Context(), bson.M{
+		"collection": s.Collection,
+		"document":   objID,
+		"rev":        number,
+	})
+
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			ctx.AbortWithError(http.StatusNotFound, errors.New("no such revision"))
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot find revision"))
+			ctx.Error(res.Err())
+		}
+		return rev, false
+	}
+
+	if err := res.Decode(&rev); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return rev, false
+	}
+
+	return rev, true
+}
+
+// pageID parses the identifier of a page in the path (must be _id), or responds with an error.
+func pageID(ctx *gin.Context) (primitive.ObjectID, bool) {
+
+	objID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid document identifier"))
+		ctx.Error(err)
+		return objID, false
+	}
+
+	return objID, true
+}
+
+// revisionNumber parses a revision number, or responds with an error.
+func revisionNumber(ctx *gin.Context, value string) (int, bool) {
+
+	n, err := strconv.Atoi(value)
+
+	if err != nil || n < 1 {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid revision number"))
+		return 0, false
+	}
+
+	return n, true
+}
+
+// getRevisionsHandler lists the revisions of a page, newest first, without their markdown and html.
+func (s *PageHandler) getRevisionsHandler(ctx *gin.Context) {
+
+	objID, ok := pageID(ctx)
+
+	if !ok {
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.M{"rev": -1}).
+		SetProjection(bson.M{"page.markdown": false, "page.html": false})
+
+	cur, err := s.DB.Collection(RevisionCollection).Find(ctx.Request.Context(), bson.M{"collection": s.Collection, "document": objID}, opts)
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	results := []models.Revision{}
+
+	if err := cur.All(ctx.Request.Context(), &results); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, results)
+}
+
+// getRevisionHandler returns a revision of a page.
+func (s *PageHandler) getRevisionHandler(ctx *gin.Context) {
+
+	objID, ok := pageID(ctx)
+
+	if !ok {
+		return
+	}
+
+	number, ok := revisionNumber(ctx, ctx.Param("rev"))
+
+	if !ok {
+		return
+	}
+
+	rev, ok := s.findRevision(ctx, objID, number)
+
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, rev)
+}
+
+// revisionDiff is the difference between the markdown of two revisions.
+type revisionDiff struct {
+	From int    `json:"from"`
+	To   int    `json:"to"`
+	Mode string `json:"mode"`
+
+	// Unified is the line-level diff (mode unified), Words the word-level diff (mode words).
+	Unified string           `json:"unified,omitempty"`
+	Words   []helpers.DiffOp `json:"words,omitempty"`
+}
+
+// getDiffHandler returns the difference between the markdown of two revisions of a page. By default, between the
+// latest revision and the one before.
+func (s *PageHandler) getDiffHandler(ctx *gin.Context) {
+
+	objID, ok := pageID(ctx)
+
+	if !ok {
+		return
+	}
+
+	mode := ctx.DefaultQuery("mode", "unified")
+
+	if mode != "unified" && mode != "words" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("mode should be unified or words"))
+		return
+	}
+
+	// lines of context around the changes, for unified diffs
+	lines, err := strconv.Atoi(ctx.DefaultQuery("context", "3"))
+
+	if err != nil || lines < 0 {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("context is not a positive number"))
+		return
+	}
+
+	diff := revisionDiff{Mode: mode}
+
+	if v, ok := ctx.GetQuery("to"); ok {
+		if diff.To, ok = revisionNumber(ctx, v); !ok {
+			return
+		}
+	} else {
+		latest, err := latestRevision(ctx.Request.Context(), s.DB, s.Collection, objID)
+
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot find revision"))
+			ctx.Error(err)
+			return
+		}
+
+		if latest == 0 {
+			ctx.AbortWithError(http.StatusNotFound, errors.New("no such revision"))
+			return
+		}
+
+		diff.To = latest
+	}
+
+	// the revision before the first one is empty
+
+	diff.From = diff.To - 1
+
+	if v, ok := ctx.GetQuery("from"); ok {
+		if diff.From, ok = revisionNumber(ctx, v); !ok {
+			return
+		}
+	}
+
+	var from models.Revision
+
+	if diff.From > 0 {
+		if from, ok = s.findRevision(ctx, objID, diff.From); !ok {
+			return
+		}
+	}
+
+	to, ok := s.findRevision(ctx, objID, diff.To)
+
+	if !ok {
+		return
+	}
+
+	if mode == "words" {
+		diff.Words = helpers.DiffWords(from.Page.Markdown, to.Page.Markdown)
+	} else {
+		diff.Unified = helpers.UnifiedDiff(from.Page.Markdown, to.Page.Markdown, "rev "+strconv.Itoa(diff.From), "rev "+strconv.Itoa(diff.To), lines)
+	}
+
+	ctx.JSON(http.StatusOK, diff)
+}
+
+// restoreRevisionHandler makes a revision the current version of a page, as a new revision. Deleted pages are
+// restored as well.
+func (s *PageHandler) restoreRevisionHandler(ctx *gin.Context) {
+
+	objID, ok := pageID(ctx)
+
+	if !ok {
+		return
+	}
+
+	number, ok := revisionNumber(ctx, ctx.Param("rev"))
+
+	if !ok {
+		return
+	}
+
+	rev, ok := s.findRevision(ctx, objID, number)
+
+	if !ok {
+		return
+	}
+
+	// generated fields: { html, page_type, last_updated }, with the current settings of the collection
+
+	page := rev.Page
+	page.ObjectID = objID
+
+	html, err := helpers.ParseMDProfile(page.Markdown, s.Settings.Markdown)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot generate html from markdown"))
+		ctx.Error(err)
+		return
+	}
+	page.HTML = html
+
+	page.PageType = s.PageType
+	page.LastUpdated = time.Now()
+	page.Updated = true
+
+	// an existing page has to be at the version of If-Match (if any), a deleted page is inserted again
+
+	var current models.Page
+
+	projection := bson.M{"version": true, "searchable_title": true, "previous_slugs": true}
+
+	err = s.DB.Collection(s.Collection).FindOne(ctx.Request.Context(), bson.M{"_id": objID}, options.FindOne().SetProjection(projection)).Decode(&current)
+
+	deleted := err == mongo.ErrNoDocuments
+
+	if err != nil && !deleted {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot find document"))
+		ctx.Error(err)
+		return
+	}
+
+	if deleted && ctx.GetHeader("If-Match") != "" {
+		ctx.AbortWithError(http.StatusPreconditionFailed, errors.New("document does not exist"))
+		return
+	}
+
+	if !deleted && !ifMatch(ctx, current.Version, s.Settings.RequireIfMatch) {
+		return
+	}
+
+	page.Version = current.Version + 1
+
+	// a deleted page continues from its highest version, saved when it was deleted (see deletePageHandler), so that
+	// ETags of the deleted page never match again
+
+	if deleted {
+		latest, err := s.latestVersion(ctx.Request.Context(), objID)
+
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot find revisions"))
+			ctx.Error(err)
+			return
+		}
+
+		page.Version = latest + 1
+	}
+
+	// the title of the revision may differ, the current one is redirected
+
+	if !deleted {
+		page.PreviousSlugs = slugHistory(current.PreviousSlugs, current.SearchableTitle, page.SearchableTitle)
+	}
+
+	filter := bson.M{"_id": objID}
+
+	if !deleted {
+		filter["version"] = VersionFilter(current.Version)
+	}
+
+	opts := options.FindOneAndReplace().SetUpsert(deleted)
+
+	res := s.DB.Collection(s.Collection).FindOneAndReplace(ctx.Request.Context(), filter, page, opts)
+
+	// no previous version: the page had been deleted
+
+	var before bson.M
+
+	if res.Err() == nil {
+		if err := res.Decode(&before); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+	} else if res.Err() == mongo.ErrNoDocuments && !deleted {
+		modifiedConcurrently(ctx)
+		return
+	} else if res.Err() != mongo.ErrNoDocuments {
+		if helpers.IsDuplicateKey(res.Err()) {
+			ctx.AbortWithError(http.StatusConflict, errors.New("page with same title exists"))
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot update document"))
+		}
+		ctx.Error(res.Err())
+		return
+	}
+
+	if _, err := s.saveRevision(ctx, page, rev.Number); err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("page restored, but revision cannot be saved"))
+		ctx.Error(err)
+		return
+	}
+
+	if before == nil {
+		s.Audit.Record(ctx, audit.ActionCreate, s.Collection, objID, nil, page)
+	} else {
+		s.Audit.Record(ctx, audit.ActionUpdate, s.Collection, objID, before, page)
+	}
+
+	ctx.Header("ETag", etag(page.Version))
+	ctx.JSON(http.StatusOK, page)
+}
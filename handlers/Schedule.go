@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/lexffe/backend.lexffe.io/audit"
+	"github.com/lexffe/backend.lexffe.io/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// schedulerActor is the actor of the audit entries of the scheduler.
+const schedulerActor = "scheduler"
+
+// publishedAt is the filter of the pages read as published at the time: published (and not scheduled for later),
+// or scheduled to be published by then, and not scheduled to be unpublished by then.
+func publishedAt(now time.Time) bson.M {
+	return bson.M{
+		"$or": bson.A{
+			bson.M{"published": true, "publish_at": bson.M{"$not": bson.M{"$gt": now}}},
+			bson.M{"publish_at": bson.M{"$lte": now}},
+		},
+		"unpublish_at": bson.M{"$not": bson.M{"$lte": now}},
+	}
+}
+
+// PublishScheduled publishes and unpublishes the pages of a collection whose time has come, saves the new versions
+// as revisions and records the changes in the audit log. The state is only in the pages, so that pages missed while the server was down are caught up
+// on the next run, and the pages are only changed if nobody (e.g. another instance) has changed them meanwhile.
+func PublishScheduled(ctx context.Context, db *mongo.Database, collection string, log *audit.Log, now time.Time) error {
+
+	coll := db.Collection(collection)
+
+	cur, err := coll.Find(ctx, bson.M{
+		"$or": bson.A{
+			bson.M{"publish_at": bson.M{"$lte": now}},
+			bson.M{"unpublish_at": bson.M{"$lte": now}},
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+
+		var before models.Page
+
+		if err := cur.Decode(&before); err != nil {
+			return err
+		}
+
+		// pages are published before they are unpublished (see CheckSchedule), one event each
+
+		type change struct {
+			action audit.Action
+			page   models.Page
+		}
+
+		var changes []change
+
+		after := before
+		after.Version++
+		unset := bson.M{}
+
+		if after.PublishAt != nil && !after.PublishAt.After(now) {
+			after.Published = true
+			after.PublishAt = nil
+			unset["publish_at"] = true
+			changes = append(changes, change{audit.ActionPublish, after})
+		}
+
+		if after.UnpublishAt != nil && !after.UnpublishAt.After(now) {
+			after.Published = false
+			after.UnpublishAt = nil
+			unset["unpublish_at"] = true
+			changes = append(changes, change{audit.ActionUnpublish, after})
+		}
+
+		// the page as written is saved as a revision: its content may have been changed since it was read
+
+		res := coll.FindOneAndUpdate(ctx, bson.M{
+			"_id":          before.ObjectID,
+			"published":    before.Published,
+			"publish_at":   before.PublishAt,
+			"unpublish_at": before.UnpublishAt,
+		}, bson.M{
+			"$set":   bson.M{"published": after.Published},
+			"$unset": unset,
+			"$inc":   bson.M{"version": int64(1)},
+		}, options.FindOneAndUpdate().SetReturnDocument(options.After))
+
+		if res.Err() == mongo.ErrNoDocuments {
+			continue
+		}
+
+		var written models.Page
+
+		if err := res.Decode(&written); err != nil {
+			return err
+		}
+
+		previous := before
+
+		for _, c := range changes {
+			log.Event(schedulerActor, c.action, collection, before.ObjectID, previous, c.page)
+			previous = c.page
+		}
+
+		if err := SaveRevisions(db, collection, &before, written, schedulerActor); err != nil {
+			return err
+		}
+	}
+
+	return cur.Err()
+}
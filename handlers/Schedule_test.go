@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestPublishScheduled(t *testing.T) {
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("publish", func(mt *mtest.T) {
+
+		now := time.Now()
+		objID := primitive.NewObjectID()
+
+		// a page saved before versions were int64, without revisions
+
+		page := bson.D{
+			{Key: "_id", Value: objID},
+			{Key: "title", Value: "title"},
+			{Key: "published", Value: false},
+			{Key: "publish_at", Value: now.Add(-time.Minute)},
+			{Key: "version", Value: int32(3)},
+		}
+
+		written := bson.D{
+			{Key: "_id", Value: objID},
+			{Key: "title", Value: "title"},
+			{Key: "published", Value: true},
+			{Key: "version", Value: int64(4)},
+		}
+
+		revisions := "test." + RevisionCollection
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.pages", mtest.FirstBatch, page),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: written}),
+
+			// no revisions yet: the previous version is saved first
+			mtest.CreateCursorResponse(0, revisions, mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, revisions, mtest.FirstBatch),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateCursorResponse(0, revisions, mtest.FirstBatch, bson.D{{Key: "rev", Value: 1}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+
+		if err := PublishScheduled(context.Background(), mt.DB, "pages", nil, now); err != nil {
+			mt.Fatal(err)
+		}
+
+		var inserted []bson.Raw
+
+		for evt := mt.GetStartedEvent(); evt != nil; evt = mt.GetStartedEvent() {
+
+			switch evt.CommandName {
+
+			case "findAndModify":
+				if inc := evt.Command.Lookup("update", "$inc", "version"); inc.Type != bsontype.Int64 {
+					mt.Errorf("version is incremented by a %v, want an int64", inc.Type)
+				}
+
+			case "insert":
+				inserted = append(inserted, evt.Command.Lookup("documents", "0").Document())
+			}
+		}
+
+		if len(inserted) != 2 {
+			mt.Fatalf("revisions saved = %v, want 2", len(inserted))
+		}
+
+		for i, version := range []int64{3, 4} {
+			if v := inserted[i].Lookup("page", "version").Int64(); v != version {
+				mt.Errorf("revision %v: version = %v, want %v", i+1, v, version)
+			}
+		}
+
+		if !inserted[1].Lookup("page", "published").Boolean() {
+			mt.Errorf("the published page is not saved as a revision")
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/helpers"
+	"github.com/lexffe/backend.lexffe.io/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SearchableTitle returns the explicit slug of a page, or its title in kebab-case.
+func SearchableTitle(page models.Page) (string, error) {
+
+	if page.Slug != "" {
+		return page.Slug, nil
+	}
+
+	return helpers.ParseKebab(page.Title)
+}
+
+// slugHistory returns the previous slugs of a page whose slug changes from current to next. The current slug is
+// kept (oldest first), the next one is no longer a previous slug.
+func slugHistory(previous []string, current string, next string) []string {
+
+	var history []string
+
+	for _, slug := range previous {
+		if slug != next && slug != current {
+			history = append(history, slug)
+		}
+	}
+
+	if current != "" && current != next {
+		history = append(history, current)
+	}
+
+	return history
+}
+
+// currentPage returns the version and slugs of a page, and checks the version against the If-Match header of the
+// request. It responds with 404 if the page does not exist.
+func (s *PageHandler) currentPage(ctx *gin.Context, objID primitive.ObjectID) (models.Page, bool) {
+
+	var page models.Page
+
+	opts := options.FindOne().SetProjection(bson.M{"version": true, "searchable_title": true, "previous_slugs": true})
+
+	err := s.DB.Collection(s.Collection).FindOne(ctx.Request.Context(), bson.M{"_id": objID}, opts).Decode(&page)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot find document"))
+		}
+		ctx.Error(err)
+		return page, false
+	}
+
+	return page, ifMatch(ctx, page.Version, s.Settings.RequireIfMatch)
+}
+
+// redirectSlug answers a request for a previous slug of a page with a redirection to its current slug. The filter
+// is the filter of the request, by searchable title. It reports whether the request has been answered.
+func (s *PageHandler) redirectSlug(ctx *gin.Context, filter bson.M, slug string) bool {
+
+	delete(filter, "searchable_title")
+	filter["previous_slugs"] = slug
+
+	// if the slug was used by several pages, the latest one wins
+
+	opts := options.FindOne().
+		SetProjection(bson.M{"searchable_title": true}).
+		SetSort(bson.M{"last_updated": -1})
+
+	var page models.Page
+
+	err := s.DB.Collection(s.Collection).FindOne(ctx.Request.Context(), filter, opts).Decode(&page)
+
+	if err == mongo.ErrNoDocuments {
+		return false
+	}
+
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot find document"))
+		ctx.Error(err)
+		return true
+	}
+
+	// same path, with the current slug as last segment
+
+	location := url.URL{
+		Path:     path.Join(path.Dir(ctx.Request.URL.Path), page.SearchableTitle),
+		RawQuery: ctx.Request.URL.RawQuery,
+	}
+
+	ctx.Redirect(http.StatusMovedPermanently, location.String())
+
+	return true
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/**
+Optimistic concurrency
+
+Pages and references have a version, incremented on every write and returned as the ETag of the document.
+Writes with an If-Match header only succeed if the document is still at that version (412 otherwise), collections
+with the require_if_match setting reject writes without it (428). The version is part of the filter of the write,
+so that a document changed between the check and the write is not overwritten either.
+*/
+
+// etag is the entity tag of a version.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// VersionFilter matches a version. Documents written before versions were kept have none, i.e. version 0.
+func VersionFilter(version int64) interface{} {
+
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+
+	return version
+}
+
+// ifMatch reports whether the If-Match header of the request matches the version, see RFC 7232. Without the
+// header, it responds with 428 if required. Otherwise, it responds with 412 if the version does not match.
+func ifMatch(ctx *gin.Context, version int64, required bool) bool {
+
+	header := ctx.GetHeader("If-Match")
+
+	if header == "" {
+		if required {
+			ctx.AbortWithError(http.StatusPreconditionRequired, errors.New("If-Match is required"))
+			return false
+		}
+		return true
+	}
+
+	// strong comparison: weak tags (W/"...") never match
+
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" || tag == etag(version) {
+			return true
+		}
+	}
+
+	ctx.Header("ETag", etag(version))
+	ctx.AbortWithError(http.StatusPreconditionFailed, errors.New("document has been modified"))
+
+	return false
+}
+
+// currentVersion returns the version of a document, and checks it against the If-Match header of the request. It
+// responds with 404 if the document does not exist.
+func currentVersion(ctx *gin.Context, coll *mongo.Collection, objID primitive.ObjectID, required bool) (int64, bool) {
+
+	var doc struct {
+		Version int64 `bson:"version"`
+	}
+
+	err := coll.FindOne(ctx.Request.Context(), bson.M{"_id": objID}, options.FindOne().SetProjection(bson.M{"version": true})).Decode(&doc)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			ctx.Status(http.StatusNotFound)
+		} else {
+			ctx.AbortWithError(http.StatusInternalServerError, errors.New("cannot find document"))
+		}
+		ctx.Error(err)
+		return 0, false
+	}
+
+	return doc.Version, ifMatch(ctx, doc.Version, required)
+}
+
+// modifiedConcurrently responds to a write whose versioned filter matched nothing, after currentVersion found the
+// document: it has been modified (or deleted) in between.
+func modifiedConcurrently(ctx *gin.Context) {
+	ctx.AbortWithError(http.StatusPreconditionFailed, errors.New("document has been modified concurrently"))
+}
@@ -0,0 +1,48 @@
+package helpers
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/**
+The http server only limits the time to read the headers of a request (ReadHeaderTimeout). The time to read the
+body and to write the response is limited per request, on the connection: requests streaming files or archives
+are allowed more time than others.
+*/
+
+const (
+	// RequestDeadline is the time a request has to be read and answered in.
+	RequestDeadline = 30 * time.Second
+
+	// StreamDeadline is the time of requests streaming files or archives, e.g. uploads, downloads, imports.
+	StreamDeadline = 1 * time.Hour
+)
+
+// connKey is the context key of the connection of a request.
+type connKey struct{}
+
+// ConnContext keeps the connection in the context of its requests, see SetDeadline. It is used as (part of) the
+// ConnContext of the http server.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// SetDeadline sets the read and write deadlines of the connection of the request to d from now.
+func SetDeadline(ctx context.Context, d time.Duration) {
+
+	if c, ok := ctx.Value(connKey{}).(net.Conn); ok {
+		c.SetDeadline(time.Now().Add(d))
+	}
+}
+
+// Deadline is a middleware setting the deadlines of every request to d, handlers can extend them.
+func Deadline(d time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		SetDeadline(ctx.Request.Context(), d)
+		ctx.Next()
+	}
+}
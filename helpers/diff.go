@@ -0,0 +1,263 @@
+package helpers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DiffOp is an operation of a diff: the text is kept, deleted or inserted.
+type DiffOp struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Operations of a diff.
+const (
+	DiffEqual  = "equal"
+	DiffDelete = "delete"
+	DiffInsert = "insert"
+)
+
+// maxDiffCells bounds the size of the LCS table (after common prefix / suffix are removed), 4MB of int32. Larger
+// changes (e.g. more than 1000 changed lines on both sides) are reported as a deletion of the old text followed by
+// an insertion of the new one.
+const maxDiffCells = 1 << 20
+
+// Diff computes the difference between the tokens a and b, by longest common subsequence.
+func Diff(a []string, b []string) []DiffOp {
+
+	var ops []DiffOp
+
+	// common prefix and suffix are kept as is
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	for _, t := range a[:prefix] {
+		ops = append(ops, DiffOp{DiffEqual, t})
+	}
+
+	ops = append(ops, lcs(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+
+	for _, t := range a[len(a)-suffix:] {
+		ops = append(ops, DiffOp{DiffEqual, t})
+	}
+
+	return ops
+}
+
+// lcs diffs a and b with the table of the lengths of their longest common subsequences.
+func lcs(a []string, b []string) []DiffOp {
+
+	var ops []DiffOp
+
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for _, t := range a {
+			ops = append(ops, DiffOp{DiffDelete, t})
+		}
+		for _, t := range b {
+			ops = append(ops, DiffOp{DiffInsert, t})
+		}
+		return ops
+	}
+
+	// table[i][j] is the length of the lcs of a[i:] and b[j:]
+
+	table := make([][]int32, len(a)+1)
+
+	for i := range table {
+		table[i] = make([]int32, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i][j] = table[i+1][j+1] + 1
+			} else if table[i+1][j] >= table[i][j+1] {
+				table[i][j] = table[i+1][j]
+			} else {
+				table[i][j] = table[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, DiffOp{DiffEqual, a[i]})
+			i++
+			j++
+		case table[i+1][j] >= table[i][j+1]:
+			ops = append(ops, DiffOp{DiffDelete, a[i]})
+			i++
+		default:
+			ops = append(ops, DiffOp{DiffInsert, b[j]})
+			j++
+		}
+	}
+
+	for ; i < len(a); i++ {
+		ops = append(ops, DiffOp{DiffDelete, a[i]})
+	}
+
+	for ; j < len(b); j++ {
+		ops = append(ops, DiffOp{DiffInsert, b[j]})
+	}
+
+	return ops
+}
+
+// words splits text into words and the whitespace between them.
+var words = regexp.MustCompile(`\s+|\S+`)
+
+// DiffWords computes the word-level difference between two texts. Consecutive operations of the same kind are
+// merged, so that the texts of the operations concatenate into the old (equal, delete) and new (equal, insert) text.
+func DiffWords(a string, b string) []DiffOp {
+
+	ops := []DiffOp{}
+
+	for _, op := range Diff(words.FindAllString(a, -1), words.FindAllString(b, -1)) {
+
+		if n := len(ops); n > 0 && ops[n-1].Op == op.Op {
+			ops[n-1].Text += op.Text
+			continue
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops
+}
+
+// UnifiedDiff computes the line-level difference between two texts, in the unified format of diff -u, with n
+// lines of context around the changes. It is empty if the texts are the same.
+func UnifiedDiff(a string, b string, fromName string, toName string, n int) string {
+
+	ops := Diff(lines(a), lines(b))
+
+	var out strings.Builder
+
+	// hunks: changes, with up to n lines of context before and after. changes closer than 2n lines are in the
+	// same hunk.
+
+	for start := 0; start < len(ops); {
+
+		// next change
+
+		first := start
+		for first < len(ops) && ops[first].Op == DiffEqual {
+			first++
+		}
+
+		if first == len(ops) {
+			break
+		}
+
+		// end of the hunk: the first run of more than 2n equal lines, or the end
+
+		last := first
+		for k := first; k < len(ops); k++ {
+			if ops[k].Op != DiffEqual {
+				last = k
+			} else if k-last > 2*n {
+				break
+			}
+		}
+
+		from := max(first-n, start)
+		to := min(last+n+1, len(ops))
+
+		// line numbers of the hunk, 1-based
+
+		aLine, bLine := 1, 1
+		for _, op := range ops[:from] {
+			if op.Op != DiffInsert {
+				aLine++
+			}
+			if op.Op != DiffDelete {
+				bLine++
+			}
+		}
+
+		aLen, bLen := 0, 0
+		for _, op := range ops[from:to] {
+			if op.Op != DiffInsert {
+				aLen++
+			}
+			if op.Op != DiffDelete {
+				bLen++
+			}
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %v\n+++ %v\n", fromName, toName)
+		}
+
+		fmt.Fprintf(&out, "@@ -%v +%v @@\n", hunkRange(aLine, aLen), hunkRange(bLine, bLen))
+
+		for _, op := range ops[from:to] {
+			switch op.Op {
+			case DiffEqual:
+				out.WriteString(" ")
+			case DiffDelete:
+				out.WriteString("-")
+			case DiffInsert:
+				out.WriteString("+")
+			}
+			out.WriteString(op.Text)
+			out.WriteString("\n")
+		}
+
+		start = to
+	}
+
+	return out.String()
+}
+
+// lines splits text into lines, without the line breaks.
+func lines(text string) []string {
+
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// hunkRange formats the range of lines of a hunk. An empty range starts at the line before it.
+func hunkRange(line int, n int) string {
+
+	if n == 0 {
+		return fmt.Sprintf("%v,0", line-1)
+	}
+
+	if n == 1 {
+		return fmt.Sprint(line)
+	}
+
+	return fmt.Sprintf("%v,%v", line, n)
+}
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
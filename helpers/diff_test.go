@@ -0,0 +1,174 @@
+package helpers
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+
+	tests := []struct {
+		name string
+		a, b []string
+		want []DiffOp
+	}{
+		{"empty", nil, nil, nil},
+		{"insert all", nil, []string{"x", "y"}, []DiffOp{{DiffInsert, "x"}, {DiffInsert, "y"}}},
+		{"delete all", []string{"x", "y"}, nil, []DiffOp{{DiffDelete, "x"}, {DiffDelete, "y"}}},
+		{"equal", []string{"x", "y"}, []string{"x", "y"}, []DiffOp{{DiffEqual, "x"}, {DiffEqual, "y"}}},
+		{
+			"insert in the middle",
+			[]string{"a", "c"},
+			[]string{"a", "b", "c"},
+			[]DiffOp{{DiffEqual, "a"}, {DiffInsert, "b"}, {DiffEqual, "c"}},
+		},
+		{
+			"replace",
+			[]string{"a", "b", "c"},
+			[]string{"a", "x", "c"},
+			[]DiffOp{{DiffEqual, "a"}, {DiffDelete, "b"}, {DiffInsert, "x"}, {DiffEqual, "c"}},
+		},
+		{
+			// the longest common subsequence is b c d, not a
+			"longest common subsequence",
+			[]string{"a", "b", "c", "d"},
+			[]string{"b", "c", "d", "a"},
+			[]DiffOp{{DiffDelete, "a"}, {DiffEqual, "b"}, {DiffEqual, "c"}, {DiffEqual, "d"}, {DiffInsert, "a"}},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := Diff(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: Diff = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiffTooLarge(t *testing.T) {
+
+	// more changed tokens than the table can hold: the old tokens are deleted, the new ones inserted
+
+	n := 2048
+
+	a := make([]string, n)
+	b := make([]string, n)
+
+	for i := range a {
+		a[i] = "a" + strconv.Itoa(i)
+		b[i] = "b" + strconv.Itoa(i)
+	}
+
+	if (n+1)*(n+1) <= maxDiffCells {
+		t.Fatalf("%v tokens fit in the table", n)
+	}
+
+	ops := Diff(append([]string{"same"}, a...), append([]string{"same"}, b...))
+
+	if len(ops) != 2*n+1 || ops[0] != (DiffOp{DiffEqual, "same"}) {
+		t.Fatalf("ops = %v, want the common prefix and %v changes", len(ops), 2*n)
+	}
+
+	for i, op := range ops[1:] {
+		if want := i < n; (op.Op == DiffDelete) != want {
+			t.Fatalf("op %v = %v, deletions should come first", i+1, op)
+		}
+	}
+}
+
+func TestDiffWords(t *testing.T) {
+
+	tests := []struct {
+		name string
+		a, b string
+		want []DiffOp
+	}{
+		{"empty", "", "", []DiffOp{}},
+		{"insert all", "", "new text", []DiffOp{{DiffInsert, "new text"}}},
+		{
+			"change a word",
+			"the quick fox",
+			"the slow fox",
+			[]DiffOp{{DiffEqual, "the "}, {DiffDelete, "quick"}, {DiffInsert, "slow"}, {DiffEqual, " fox"}},
+		},
+		{
+			"whitespace",
+			"one two",
+			"one  two",
+			[]DiffOp{{DiffEqual, "one"}, {DiffDelete, " "}, {DiffInsert, "  "}, {DiffEqual, "two"}},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := DiffWords(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: DiffWords = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+
+	letters := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	changed := "a\nB\nc\nd\ne\nf\ng\nh\nI\nj\n"
+
+	// expected outputs are those of diff -u
+
+	tests := []struct {
+		name    string
+		a, b    string
+		context int
+		want    string
+	}{
+		{"same", letters, letters, 3, ""},
+		{"empty", "", "", 3, ""},
+		{
+			"from empty",
+			"", "x\ny\n", 3,
+			"--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n",
+		},
+		{
+			"to empty",
+			"x\ny\n", "", 3,
+			"--- a\n+++ b\n@@ -1,2 +0,0 @@\n-x\n-y\n",
+		},
+		{
+			"separate hunks",
+			letters, changed, 1,
+			"--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n@@ -8,3 +8,3 @@\n h\n-i\n+I\n j\n",
+		},
+		{
+			// 6 lines between the changes: the context of both overlaps
+			"merged hunks",
+			letters, changed, 3,
+			"--- a\n+++ b\n@@ -1,10 +1,10 @@\n a\n-b\n+B\n c\n d\n e\n f\n g\n h\n-i\n+I\n j\n",
+		},
+		{
+			"no context",
+			letters, changed, 0,
+			"--- a\n+++ b\n@@ -2 +2 @@\n-b\n+B\n@@ -9 +9 @@\n-i\n+I\n",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := UnifiedDiff(tt.a, tt.b, "a", "b", tt.context); got != tt.want {
+			t.Errorf("%v: UnifiedDiff =\n%v\nwant\n%v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnifiedDiffLineNumbers(t *testing.T) {
+
+	// a change after inserted lines: the hunk starts at different lines in a and b
+
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+	b := "0\n1\n2\n3\n4\n5\n6\n7\nX\n9\n"
+
+	got := UnifiedDiff(a, b, "a", "b", 1)
+
+	for _, header := range []string{"@@ -1 +1,2 @@", "@@ -7,3 +8,3 @@"} {
+		if !strings.Contains(got, header+"\n") {
+			t.Errorf("no hunk %v in\n%v", header, got)
+		}
+	}
+}
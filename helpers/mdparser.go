@@ -9,10 +9,27 @@ import (
 
 // ParseMD is a helper function to parse markdown into html + sanitising.
 func ParseMD(markdown string) (string, error) {
+	return ParseMDProfile(markdown, "ugc")
+}
+
+// ParseMDProfile parses markdown into html, sanitised according to the profile:
+// "ugc" allows the html of user generated content, "strict" strips all html (text only),
+// "trusted" does not sanitise at all, for collections only written by trusted editors.
+func ParseMDProfile(markdown string, profile string) (string, error) {
 	var html bytes.Buffer
 
 	unsafeHTML := mdlib.ToHTML([]byte(markdown), nil, nil)
-	_, err := html.Write(bluemonday.UGCPolicy().SanitizeBytes(unsafeHTML))
+
+	var err error
+
+	switch profile {
+	case "trusted":
+		_, err = html.Write(unsafeHTML)
+	case "strict":
+		_, err = html.Write(bluemonday.StrictPolicy().SanitizeBytes(unsafeHTML))
+	default:
+		_, err = html.Write(bluemonday.UGCPolicy().SanitizeBytes(unsafeHTML))
+	}
 
 	if err != nil {
 		return "", err
@@ -0,0 +1,35 @@
+package helpers
+
+import "go.mongodb.org/mongo-driver/mongo"
+
+// IsDuplicateKey reports whether err is a mongodb duplicate key error (E11000).
+func IsDuplicateKey(err error) bool {
+
+	switch e := err.(type) {
+
+	case mongo.WriteException:
+		for _, we := range e.WriteErrors {
+			if we.Code == 11000 {
+				return true
+			}
+		}
+
+	case mongo.CommandError:
+		return e.Code == 11000
+
+	}
+
+	return false
+}
+
+// IsNamespaceNotFound reports whether err is a mongodb error for a collection that does not exist.
+func IsNamespaceNotFound(err error) bool {
+	e, ok := err.(mongo.CommandError)
+	return ok && e.Code == 26
+}
+
+// IsNamespaceExists reports whether err is a mongodb error for a collection that already exists.
+func IsNamespaceExists(err error) bool {
+	e, ok := err.(mongo.CommandError)
+	return ok && e.Code == 48
+}
@@ -0,0 +1,39 @@
+package helpers
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// OperatorField finds a field name starting with $ (an operator in mongo), in nested documents and arrays as well.
+func OperatorField(v interface{}) (string, bool) {
+
+	switch v := v.(type) {
+
+	case bson.M:
+		return OperatorField(map[string]interface{}(v))
+
+	case map[string]interface{}:
+		for k, child := range v {
+			if strings.HasPrefix(k, "$") {
+				return k, true
+			}
+			if k, ok := OperatorField(child); ok {
+				return k, true
+			}
+		}
+
+	case bson.A:
+		return OperatorField([]interface{}(v))
+
+	case []interface{}:
+		for _, child := range v {
+			if k, ok := OperatorField(child); ok {
+				return k, true
+			}
+		}
+	}
+
+	return "", false
+}
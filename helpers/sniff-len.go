@@ -0,0 +1,4 @@
+package helpers
+
+// SniffLen is the number of bytes used to detect the content type of a file, see http.DetectContentType.
+const SniffLen = 512
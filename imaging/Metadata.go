@@ -0,0 +1,405 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+)
+
+// errMalformed is returned for images whose structure cannot be parsed.
+var errMalformed = errors.New("malformed image")
+
+// Strip removes metadata (EXIF, XMP, IPTC, comments and text chunks) from an image, without re-encoding the
+// pixels. The EXIF orientation of a jpeg is lost with the metadata, so a rotated jpeg is re-encoded upright.
+// Other content types are returned as they are.
+func Strip(ctx context.Context, data []byte, mimeType string) ([]byte, error) {
+
+	switch mimeType {
+	case "image/jpeg":
+		return stripJPEG(ctx, data)
+	case "image/png":
+		return stripPNG(data)
+	case "image/webp":
+		return stripWebP(data)
+	case "image/gif":
+		return stripGIF(data)
+	}
+
+	return data, nil
+}
+
+// stripJPEG keeps the JFIF (APP0), ICC profile (APP2) and Adobe (APP14) segments, other application segments
+// and comments are removed.
+func stripJPEG(ctx context.Context, data []byte) ([]byte, error) {
+
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+
+	orientation := 1
+	i := 2
+
+	for {
+
+		// markers may be preceded by fill bytes
+
+		for i < len(data) && data[i] == 0xff && i+1 < len(data) && data[i+1] == 0xff {
+			i++
+		}
+
+		if i+4 > len(data) || data[i] != 0xff {
+			return nil, errMalformed
+		}
+
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+
+		if length < 2 || i+2+length > len(data) {
+			return nil, errMalformed
+		}
+
+		segment := data[i : i+2+length]
+		i += 2 + length
+
+		switch {
+
+		case marker == 0xe1:
+			if o, ok := exifOrientation(segment[4:]); ok {
+				orientation = o
+			}
+
+		case marker >= 0xe0 && marker <= 0xef && marker != 0xe0 && marker != 0xe2 && marker != 0xee:
+			// other application segments
+
+		case marker == 0xfe:
+			// comment
+
+		case marker == 0xda:
+			// start of scan: the rest is image data
+			out = append(out, segment...)
+			out = append(out, data[i:]...)
+			return orient(ctx, out, orientation)
+
+		default:
+			out = append(out, segment...)
+		}
+	}
+}
+
+// exifOrientation reads the orientation tag of the EXIF segment payload.
+func exifOrientation(payload []byte) (int, bool) {
+
+	if len(payload) < 14 || string(payload[:6]) != "Exif\x00\x00" {
+		return 0, false
+	}
+
+	tiff := payload[6:]
+
+	var order binary.ByteOrder
+
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, false
+	}
+
+	// IFD0: entry count, then 12 byte entries of tag, type, count and value
+
+	ifd := int(order.Uint32(tiff[4:]))
+
+	if ifd+2 > len(tiff) {
+		return 0, false
+	}
+
+	n := int(order.Uint16(tiff[ifd:]))
+
+	for e := 0; e < n; e++ {
+
+		entry := ifd + 2 + e*12
+
+		if entry+12 > len(tiff) {
+			return 0, false
+		}
+
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8:]))
+			return o, o >= 1 && o <= 8
+		}
+	}
+
+	return 0, false
+}
+
+// orient re-encodes a jpeg with an EXIF orientation other than 1 (upright).
+func orient(ctx context.Context, data []byte, orientation int) ([]byte, error) {
+
+	if orientation == 1 {
+		return data, nil
+	}
+
+	img, release, err := Decode(ctx, data)
+
+	if err == ErrTooLarge {
+		// kept as it is, without the orientation
+		return data, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// orientations 5 to 8 swap width and height
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	if orientation >= 5 {
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+
+			var dx, dy int
+
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+
+	var buf bytes.Buffer
+
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 95}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// stripPNG removes the text, time and EXIF chunks.
+func stripPNG(data []byte) ([]byte, error) {
+
+	const signature = "\x89PNG\r\n\x1a\n"
+
+	if len(data) < len(signature) || string(data[:len(signature)]) != signature {
+		return nil, errMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, signature...)
+
+	// chunks: length, type, data, crc
+
+	for i := len(signature); i < len(data); {
+
+		if i+12 > len(data) {
+			return nil, errMalformed
+		}
+
+		length := int(binary.BigEndian.Uint32(data[i:]))
+
+		if length < 0 || i+12+length > len(data) {
+			return nil, errMalformed
+		}
+
+		chunk := data[i : i+12+length]
+		i += 12 + length
+
+		switch string(chunk[4:8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+			continue
+		}
+
+		out = append(out, chunk...)
+	}
+
+	return out, nil
+}
+
+// stripWebP removes the EXIF and XMP chunks of an extended webp.
+func stripWebP(data []byte) ([]byte, error) {
+
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errMalformed
+	}
+
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+
+	// chunks: fourcc, size, data padded to an even size
+
+	for i := 12; i < len(data); {
+
+		if i+8 > len(data) {
+			return nil, errMalformed
+		}
+
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size&1
+
+		if size < 0 || end > len(data) {
+			if i+8+size == len(data) {
+				// unpadded last chunk
+				end = len(data)
+			} else {
+				return nil, errMalformed
+			}
+		}
+
+		chunk := data[i:end]
+		i = end
+
+		switch string(chunk[:4]) {
+
+		case "EXIF", "XMP ":
+			continue
+
+		case "VP8X":
+			if len(chunk) < 9 {
+				return nil, errMalformed
+			}
+
+			// clear the EXIF and XMP flags
+			chunk = append([]byte{}, chunk...)
+			chunk[8] &^= 0x08 | 0x04
+		}
+
+		out = append(out, chunk...)
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+
+	return out, nil
+}
+
+// stripGIF removes the comment and application extensions, except the looping of animations (NETSCAPE2.0).
+// Anything after the trailer is dropped.
+func stripGIF(data []byte) ([]byte, error) {
+
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return nil, errMalformed
+	}
+
+	// header, logical screen descriptor and global color table
+
+	i := 13
+
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+
+	if i > len(data) {
+		return nil, errMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:i]...)
+
+	for i < len(data) {
+
+		start := i
+
+		switch data[i] {
+
+		case 0x3b: // trailer
+			return append(out, data[i]), nil
+
+		case 0x21: // extension: label, sub-blocks
+			if i+2 > len(data) {
+				return nil, errMalformed
+			}
+
+			label := data[i+1]
+			end, ok := gifSubBlocks(data, i+2)
+
+			if !ok {
+				return nil, errMalformed
+			}
+
+			i = end
+
+			switch label {
+			case 0xfe: // comment
+				continue
+			case 0xff: // application
+				if !bytes.HasPrefix(data[start+2:end], []byte("\x0bNETSCAPE2.0")) {
+					continue
+				}
+			}
+
+		case 0x2c: // image: descriptor, local color table, lzw code size, sub-blocks
+			if i+10 > len(data) {
+				return nil, errMalformed
+			}
+
+			j := i + 10
+
+			if data[i+9]&0x80 != 0 {
+				j += 3 << (data[i+9]&0x07 + 1)
+			}
+
+			end, ok := gifSubBlocks(data, j+1)
+
+			if !ok {
+				return nil, errMalformed
+			}
+
+			i = end
+
+		default:
+			return nil, errMalformed
+		}
+
+		out = append(out, data[start:i]...)
+	}
+
+	// no trailer
+	return nil, errMalformed
+}
+
+// gifSubBlocks returns the end of the data sub-blocks starting at i, after the block terminator.
+func gifSubBlocks(data []byte, i int) (int, bool) {
+
+	for i < len(data) {
+
+		size := int(data[i])
+		i++
+
+		if size == 0 {
+			return i, true
+		}
+
+		i += size
+	}
+
+	return 0, false
+}
@@ -0,0 +1,325 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// secret is hidden in the metadata of the test images, it must not survive stripping.
+const secret = "GPS 51.5007 -0.1246"
+
+func testImage(w, h int) *image.NRGBA {
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 8), G: uint8(y * 8), B: 128, A: 255})
+		}
+	}
+
+	return img
+}
+
+// exif returns an EXIF payload with the orientation, and the secret as image description.
+func exif(orientation uint16) []byte {
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+
+	// IFD0: orientation (SHORT), image description (ASCII, at the end)
+
+	ifd := make([]byte, 2+2*12+4)
+	binary.BigEndian.PutUint16(ifd[0:], 2)
+
+	binary.BigEndian.PutUint16(ifd[2:], 0x0112)
+	binary.BigEndian.PutUint16(ifd[4:], 3)
+	binary.BigEndian.PutUint32(ifd[6:], 1)
+	binary.BigEndian.PutUint16(ifd[10:], orientation)
+
+	binary.BigEndian.PutUint16(ifd[14:], 0x010e)
+	binary.BigEndian.PutUint16(ifd[16:], 2)
+	binary.BigEndian.PutUint32(ifd[18:], uint32(len(secret)+1))
+	binary.BigEndian.PutUint32(ifd[22:], uint32(len(tiff)+len(ifd)))
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	payload = append(payload, ifd...)
+	payload = append(payload, secret...)
+
+	return append(payload, 0)
+}
+
+// jpegSegment returns a jpeg marker segment.
+func jpegSegment(marker byte, payload []byte) []byte {
+
+	segment := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+
+	return append(segment, payload...)
+}
+
+// jpegWithMetadata encodes the image as a jpeg with EXIF, XMP, IPTC and comment segments after SOI.
+func jpegWithMetadata(t *testing.T, img image.Image, orientation uint16) []byte {
+
+	var buf bytes.Buffer
+
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, jpegSegment(0xe1, exif(orientation))...)
+	out = append(out, jpegSegment(0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>"+secret+"</x:xmpmeta>"))...)
+	out = append(out, jpegSegment(0xed, []byte("Photoshop 3.0\x008BIM"+secret))...)
+	out = append(out, jpegSegment(0xfe, []byte(secret))...)
+
+	return append(out, data[2:]...)
+}
+
+func TestStripJPEG(t *testing.T) {
+
+	data := jpegWithMetadata(t, testImage(32, 16), 1)
+
+	stripped, err := Strip(context.Background(), data, "image/jpeg")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(stripped, []byte(secret)) {
+		t.Errorf("metadata was not stripped")
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(stripped))
+
+	if err != nil {
+		t.Fatalf("stripped image cannot be decoded: %v", err)
+	}
+
+	if img.Bounds().Dx() != 32 || img.Bounds().Dy() != 16 {
+		t.Errorf("size = %v, want 32x16", img.Bounds().Size())
+	}
+}
+
+func TestStripJPEGOrientation(t *testing.T) {
+
+	// 6: rotated 90° clockwise, the stripped image is re-encoded upright with width and height swapped
+
+	stripped, err := Strip(context.Background(), jpegWithMetadata(t, testImage(32, 16), 6), "image/jpeg")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(stripped, []byte(secret)) {
+		t.Errorf("metadata was not stripped")
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(stripped))
+
+	if err != nil {
+		t.Fatalf("stripped image cannot be decoded: %v", err)
+	}
+
+	if img.Bounds().Dx() != 16 || img.Bounds().Dy() != 32 {
+		t.Errorf("size = %v, want 16x32", img.Bounds().Size())
+	}
+}
+
+// pngChunk returns a png chunk.
+func pngChunk(kind string, payload []byte) []byte {
+
+	chunk := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	copy(chunk[4:], kind)
+	chunk = append(chunk, payload...)
+
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+
+	return append(chunk, crc...)
+}
+
+func TestStripPNG(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, testImage(8, 8)); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	// after the IHDR chunk (signature, 4 + 4 + 13 + 4)
+
+	ihdr := 8 + 25
+
+	out := append([]byte{}, data[:ihdr]...)
+	out = append(out, pngChunk("tEXt", []byte("Comment\x00"+secret))...)
+	out = append(out, pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"+secret))...)
+	out = append(out, pngChunk("eXIf", exif(1)[6:])...)
+	out = append(out, pngChunk("tIME", []byte{0x07, 0xe4, 1, 1, 0, 0, 0})...)
+	out = append(out, data[ihdr:]...)
+
+	stripped, err := Strip(context.Background(), out, "image/png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(stripped, []byte(secret)) || bytes.Contains(stripped, []byte("tIME")) {
+		t.Errorf("metadata was not stripped")
+	}
+
+	if !bytes.Equal(stripped, data) {
+		t.Errorf("stripped png differs from the original")
+	}
+}
+
+// riffChunk returns a chunk of a webp, padded to an even size.
+func riffChunk(fourcc string, payload []byte) []byte {
+
+	chunk := make([]byte, 8, 8+len(payload)+1)
+	copy(chunk, fourcc)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+
+	return chunk
+}
+
+func TestStripWebP(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	if err := EncodeWebP(&buf, testImage(8, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	// extended format: VP8X (with the EXIF and XMP flags), the VP8L chunk, EXIF and XMP
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x08 | 0x04
+	vp8x[4] = 8 - 1
+	vp8x[7] = 4 - 1
+
+	body := []byte("WEBP")
+	body = append(body, riffChunk("VP8X", vp8x)...)
+	body = append(body, buf.Bytes()[12:]...)
+	body = append(body, riffChunk("EXIF", exif(1)[6:])...)
+	body = append(body, riffChunk("XMP ", []byte("<x:xmpmeta>"+secret+"</x:xmpmeta>"))...)
+
+	data := append([]byte("RIFF\x00\x00\x00\x00"), body...)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(body)))
+
+	stripped, err := Strip(context.Background(), data, "image/webp")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(stripped, []byte(secret)) {
+		t.Errorf("metadata was not stripped")
+	}
+
+	// only the flags of VP8X are changed, the image chunk is kept as it is
+
+	vp8x[0] &^= 0x08 | 0x04
+
+	want := []byte("WEBP")
+	want = append(want, riffChunk("VP8X", vp8x)...)
+	want = append(want, buf.Bytes()[12:]...)
+	want = append([]byte("RIFF\x00\x00\x00\x00"), want...)
+	binary.LittleEndian.PutUint32(want[4:], uint32(len(want)-8))
+
+	if !bytes.Equal(stripped, want) {
+		t.Errorf("stripped webp = %x, want %x", stripped, want)
+	}
+}
+
+// gifExtension returns a gif extension of a single data sub-block.
+func gifExtension(label byte, payload []byte) []byte {
+	return append(append([]byte{0x21, label, byte(len(payload))}, payload...), 0)
+}
+
+func TestStripGIF(t *testing.T) {
+
+	paletted := image.NewPaletted(image.Rect(0, 0, 8, 8), color.Palette{color.Black, color.White})
+
+	var buf bytes.Buffer
+
+	if err := gif.EncodeAll(&buf, &gif.GIF{Image: []*image.Paletted{paletted, paletted}, Delay: []int{10, 10}}); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	// after the header, the logical screen descriptor and the global color table
+
+	i := 13
+
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+
+	loop := gifExtension(0xff, []byte("NETSCAPE2.0"))
+	loop = append(loop[:len(loop)-1], 3, 1, 0, 0, 0)
+
+	out := append([]byte{}, data[:i]...)
+	out = append(out, gifExtension(0xfe, []byte(secret))...)
+	out = append(out, gifExtension(0xff, []byte("XMP DataXMP<x:xmpmeta>"+secret+"</x:xmpmeta>"))...)
+	out = append(out, loop...)
+	out = append(out, data[i:]...)
+
+	// data after the trailer
+	out = append(out, secret...)
+
+	stripped, err := Strip(context.Background(), out, "image/gif")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(stripped, []byte(secret)) {
+		t.Errorf("metadata was not stripped")
+	}
+
+	if !bytes.Contains(stripped, loop) {
+		t.Errorf("the looping extension was stripped")
+	}
+
+	if stripped[len(stripped)-1] != 0x3b {
+		t.Errorf("stripped gif does not end with the trailer")
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(stripped))
+
+	if err != nil {
+		t.Fatalf("stripped image cannot be decoded: %v", err)
+	}
+
+	if len(g.Image) != 2 {
+		t.Errorf("frames = %v, want 2", len(g.Image))
+	}
+}
+
+func TestStripMalformed(t *testing.T) {
+
+	for _, mimeType := range []string{"image/jpeg", "image/png", "image/webp", "image/gif"} {
+		if _, err := Strip(context.Background(), []byte("not an image"), mimeType); err != errMalformed {
+			t.Errorf("%v: err = %v, want %v", mimeType, err, errMalformed)
+		}
+	}
+}
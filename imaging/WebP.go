@@ -0,0 +1,423 @@
+package imaging
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+)
+
+/**
+A minimal lossless WebP (VP8L) encoder.
+
+The pixels are coded with the subtract-green transform and one set of prefix (Huffman) codes for the whole
+image, without backward references or a color cache. The files are larger than those of libwebp, but they
+are valid WebP and need no cgo.
+
+See https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification
+*/
+
+// maxWebPSize is the maximum width or height of a VP8L image.
+const maxWebPSize = 1 << 14
+
+const (
+	// alphabet sizes of the prefix codes: green (literals and lengths), red, blue, alpha and distance.
+	greenAlphabet    = 256 + 24
+	literalAlphabet  = 256
+	distanceAlphabet = 40
+
+	// maximum code lengths of the prefix codes and of the code length code.
+	maxCodeLength       = 15
+	maxCodeLengthLength = 7
+)
+
+// codeLengthOrder is the order the code lengths of the code length code are written in.
+var codeLengthOrder = [...]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// EncodeWebP writes the image as a lossless WebP.
+func EncodeWebP(w io.Writer, img image.Image) error {
+
+	b := img.Bounds()
+
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > maxWebPSize || b.Dy() > maxWebPSize {
+		return errors.New("webp: invalid image size")
+	}
+
+	nrgba, ok := img.(*image.NRGBA)
+
+	if !ok || nrgba.Rect.Min != (image.Point{}) {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(nrgba, nrgba.Rect, img, b.Min, draw.Src)
+	}
+
+	data := encodeVP8L(nrgba)
+
+	// RIFF container: "RIFF" size "WEBP", then the single "VP8L" chunk, padded to an even size.
+
+	chunk := uint32(len(data))
+	padded := chunk + chunk&1
+
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], 4+8+padded)
+	copy(header[8:], "WEBP")
+	copy(header[12:], "VP8L")
+	binary.LittleEndian.PutUint32(header[16:], chunk)
+
+	bw := bufio.NewWriter(w)
+
+	bw.Write(header)
+	bw.Write(data)
+
+	if chunk&1 == 1 {
+		bw.WriteByte(0)
+	}
+
+	return bw.Flush()
+}
+
+// encodeVP8L encodes the VP8L bitstream of the image.
+func encodeVP8L(img *image.NRGBA) []byte {
+
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+
+	// pixels as argb, with the subtract-green transform applied
+
+	pixels := make([][4]uint8, 0, width*height)
+	opaque := true
+
+	for y := 0; y < height; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+width*4]
+
+		for x := 0; x < width; x++ {
+			r, g, b, a := row[x*4], row[x*4+1], row[x*4+2], row[x*4+3]
+
+			if a != 0xff {
+				opaque = false
+			}
+
+			pixels = append(pixels, [4]uint8{g, r - g, b - g, a})
+		}
+	}
+
+	// histograms of the green, red, blue and alpha prefix codes
+
+	histograms := [4][]int{
+		make([]int, greenAlphabet),
+		make([]int, literalAlphabet),
+		make([]int, literalAlphabet),
+		make([]int, literalAlphabet),
+	}
+
+	for _, p := range pixels {
+		for i, v := range p {
+			histograms[i][v]++
+		}
+	}
+
+	var codes [4]prefixCode
+
+	for i, h := range histograms {
+		codes[i] = newPrefixCode(h, maxCodeLength)
+	}
+
+	bw := &bitWriter{}
+
+	// header: signature, 14 bit width and height, alpha hint, 3 bit version
+
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+
+	if opaque {
+		bw.write(0, 1)
+	} else {
+		bw.write(1, 1)
+	}
+
+	bw.write(0, 3)
+
+	// transforms: subtract green, then none
+
+	bw.write(1, 1)
+	bw.write(2, 2)
+	bw.write(0, 1)
+
+	// no color cache, no meta prefix codes
+
+	bw.write(0, 1)
+	bw.write(0, 1)
+
+	for _, c := range codes {
+		c.writeTo(bw)
+	}
+
+	// the distance code is not used, a single symbol
+
+	bw.write(1, 1)
+	bw.write(0, 1)
+	bw.write(0, 1)
+	bw.write(0, 1)
+
+	for _, p := range pixels {
+		for i, v := range p {
+			codes[i].writeSymbol(bw, int(v))
+		}
+	}
+
+	return bw.bytes()
+}
+
+// prefixCode is a canonical Huffman code.
+type prefixCode struct {
+	lengths []uint8
+
+	// codes are bit-reversed, the stream is read least significant bit first.
+	codes []uint32
+
+	// symbols are the symbols with a code, in order.
+	symbols []int
+}
+
+// newPrefixCode builds a canonical Huffman code for the histogram, with code lengths up to limit.
+func newPrefixCode(histogram []int, limit int) prefixCode {
+
+	c := prefixCode{
+		lengths: huffmanLengths(histogram, limit),
+		codes:   make([]uint32, len(histogram)),
+	}
+
+	for s, l := range c.lengths {
+		if l > 0 {
+			c.symbols = append(c.symbols, s)
+		}
+	}
+
+	// canonical codes, in order of length, then symbol
+
+	var count [maxCodeLength + 2]uint32
+	var next [maxCodeLength + 2]uint32
+
+	for _, l := range c.lengths {
+		count[l]++
+	}
+
+	count[0] = 0
+
+	for l := 1; l < len(next); l++ {
+		next[l] = (next[l-1] + count[l-1]) << 1
+	}
+
+	for s, l := range c.lengths {
+		if l > 0 {
+			c.codes[s] = reverseBits(next[l], l)
+			next[l]++
+		}
+	}
+
+	return c
+}
+
+// writeTo writes the code lengths of the code.
+func (c prefixCode) writeTo(bw *bitWriter) {
+
+	// one or two symbols below 256 have a simple code
+
+	if len(c.symbols) <= 2 && c.symbols[len(c.symbols)-1] < 256 {
+
+		bw.write(1, 1)
+		bw.write(uint32(len(c.symbols)-1), 1)
+
+		if c.symbols[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(c.symbols[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(c.symbols[0]), 8)
+		}
+
+		if len(c.symbols) == 2 {
+			bw.write(uint32(c.symbols[1]), 8)
+		}
+
+		return
+	}
+
+	// normal code: the code lengths are coded with the code length code
+
+	histogram := make([]int, len(codeLengthOrder))
+
+	for _, l := range c.lengths {
+		histogram[l]++
+	}
+
+	lengthCode := newPrefixCode(histogram, maxCodeLengthLength)
+
+	n := len(codeLengthOrder)
+
+	for n > 4 && lengthCode.lengths[codeLengthOrder[n-1]] == 0 {
+		n--
+	}
+
+	bw.write(0, 1)
+	bw.write(uint32(n-4), 4)
+
+	for _, s := range codeLengthOrder[:n] {
+		bw.write(uint32(lengthCode.lengths[s]), 3)
+	}
+
+	// every symbol has a code length
+
+	bw.write(0, 1)
+
+	for _, l := range c.lengths {
+		lengthCode.writeSymbol(bw, int(l))
+	}
+}
+
+// writeSymbol writes the code of a symbol. A code with a single symbol uses no bits.
+func (c prefixCode) writeSymbol(bw *bitWriter, s int) {
+
+	if len(c.symbols) > 1 {
+		bw.write(c.codes[s], uint(c.lengths[s]))
+	}
+}
+
+// huffmanLengths computes the code lengths of a Huffman code for the histogram. If the code is deeper than
+// limit, the counts are halved until it fits.
+func huffmanLengths(histogram []int, limit int) []uint8 {
+
+	counts := make([]int, len(histogram))
+	copy(counts, histogram)
+
+	for {
+		lengths, depth := huffmanTree(counts)
+
+		if depth <= limit {
+			return lengths
+		}
+
+		for i, v := range counts {
+			if v > 0 {
+				counts[i] = (v + 1) / 2
+			}
+		}
+	}
+}
+
+// huffmanTree computes the code lengths of an (unlimited) Huffman code, and the maximum length. A single
+// symbol has length 1.
+func huffmanTree(counts []int) ([]uint8, int) {
+
+	type node struct {
+		count   int
+		symbols []int
+	}
+
+	var nodes []node
+
+	for s, v := range counts {
+		if v > 0 {
+			nodes = append(nodes, node{count: v, symbols: []int{s}})
+		}
+	}
+
+	lengths := make([]uint8, len(counts))
+
+	if len(nodes) == 1 {
+		lengths[nodes[0].symbols[0]] = 1
+		return lengths, 1
+	}
+
+	// merge the two least frequent nodes, every symbol below gets one bit longer
+
+	for len(nodes) > 1 {
+
+		a, b := 0, 1
+
+		if nodes[b].count < nodes[a].count {
+			a, b = b, a
+		}
+
+		for i := 2; i < len(nodes); i++ {
+			if nodes[i].count < nodes[a].count {
+				a, b = i, a
+			} else if nodes[i].count < nodes[b].count {
+				b = i
+			}
+		}
+
+		merged := node{
+			count:   nodes[a].count + nodes[b].count,
+			symbols: append(append([]int{}, nodes[a].symbols...), nodes[b].symbols...),
+		}
+
+		for _, s := range merged.symbols {
+			lengths[s]++
+		}
+
+		if a > b {
+			a, b = b, a
+		}
+
+		nodes[a] = merged
+		nodes = append(nodes[:b], nodes[b+1:]...)
+	}
+
+	depth := 0
+
+	for _, l := range lengths {
+		if int(l) > depth {
+			depth = int(l)
+		}
+	}
+
+	return lengths, depth
+}
+
+// reverseBits reverses the n lowest bits of v.
+func reverseBits(v uint32, n uint8) uint32 {
+
+	var r uint32
+
+	for i := uint8(0); i < n; i++ {
+		r = r<<1 | v&1
+		v >>= 1
+	}
+
+	return r
+}
+
+// bitWriter writes a bitstream, least significant bit first.
+type bitWriter struct {
+	buf   []byte
+	bits  uint64
+	nbits uint
+}
+
+// write writes the n lowest bits of v.
+func (w *bitWriter) write(v uint32, n uint) {
+
+	w.bits |= uint64(v&(1<<n-1)) << w.nbits
+	w.nbits += n
+
+	for w.nbits >= 8 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits >>= 8
+		w.nbits -= 8
+	}
+}
+
+// bytes flushes the remaining bits and returns the stream.
+func (w *bitWriter) bytes() []byte {
+
+	if w.nbits > 0 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits = 0
+		w.nbits = 0
+	}
+
+	return w.buf
+}
@@ -0,0 +1,111 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// testImages are images of a few shapes and contents, for lossless round trips.
+func testImages() map[string]image.Image {
+
+	images := map[string]image.Image{}
+
+	// a single pixel, and a single color: prefix codes of a single symbol
+
+	single := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	single.SetNRGBA(0, 0, color.NRGBA{R: 12, G: 34, B: 56, A: 78})
+	images["single pixel"] = single
+
+	flat := image.NewNRGBA(image.Rect(0, 0, 17, 9))
+	for i := range flat.Pix {
+		flat.Pix[i] = 200
+	}
+	images["single color"] = flat
+
+	// a gradient with alpha, of odd width and height
+
+	gradient := image.NewNRGBA(image.Rect(0, 0, 33, 21))
+	for y := 0; y < 21; y++ {
+		for x := 0; x < 33; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 7), G: uint8(y * 11), B: uint8(x * y), A: uint8(255 - x - y)})
+		}
+	}
+	images["gradient"] = gradient
+
+	// noise: every symbol is used, long codes
+
+	noise := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	rand.New(rand.NewSource(1)).Read(noise.Pix)
+	images["noise"] = noise
+
+	// another color model, with bounds not at the origin
+
+	rgba := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			rgba.Set(x, y, color.RGBA{R: uint8(x * 6), G: 128, B: uint8(y * 8), A: 255})
+		}
+	}
+	images["sub image"] = rgba.SubImage(image.Rect(5, 7, 35, 27))
+
+	return images
+}
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+
+	for name, img := range testImages() {
+
+		var buf bytes.Buffer
+
+		if err := EncodeWebP(&buf, img); err != nil {
+			t.Errorf("%v: encode: %v", name, err)
+			continue
+		}
+
+		if n := len(buf.Bytes()); n%2 != 0 {
+			t.Errorf("%v: file size %v is not even", name, n)
+		}
+
+		decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+
+		if err != nil {
+			t.Errorf("%v: decode: %v", name, err)
+			continue
+		}
+
+		b := img.Bounds()
+
+		if decoded.Bounds().Dx() != b.Dx() || decoded.Bounds().Dy() != b.Dy() {
+			t.Errorf("%v: size = %v, want %v", name, decoded.Bounds().Size(), b.Size())
+			continue
+		}
+
+	pixels:
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+
+				want := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y))
+				got := color.NRGBAModel.Convert(decoded.At(x, y))
+
+				if got != want {
+					t.Errorf("%v: pixel (%v, %v) = %v, want %v", name, x, y, got, want)
+					break pixels
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeWebPInvalidSize(t *testing.T) {
+
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 0, 0), image.Rect(0, 0, maxWebPSize+1, 1)} {
+		if err := EncodeWebP(&bytes.Buffer{}, image.NewNRGBA(r)); err == nil {
+			t.Errorf("%v: no error", r)
+		}
+	}
+}
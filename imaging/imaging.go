@@ -0,0 +1,223 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	_ "image/gif" // register the gif decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/buckket/go-blurhash"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // register the webp decoder
+	"golang.org/x/sync/semaphore"
+)
+
+/**
+This package contains the image pipeline of asset collections: metadata stripping, dimensions and blurhash on
+upload, and resized / converted derivatives on demand. Everything is pure go.
+*/
+
+// output formats of derivatives.
+const (
+	JPEG = "jpeg"
+	PNG  = "png"
+	WebP = "webp"
+)
+
+// maxPixels is the largest image (width * height) that is decoded, to bound the memory of a decode (up to 4 bytes
+// per pixel). 6000 x 4000 photos fit.
+const maxPixels = 32 << 20
+
+// maxDecoding is the number of pixels decoded at once, across requests. Larger decodes wait for others to finish,
+// as long as their context allows.
+const maxDecoding = 64 << 20
+
+// decoding holds the pixels of the images being decoded, see Decode.
+var decoding = semaphore.NewWeighted(maxDecoding)
+
+// jpegQuality is the quality of encoded jpegs.
+const jpegQuality = 85
+
+// ErrTooLarge is returned for images with more than maxPixels pixels.
+var ErrTooLarge = errors.New("image is too large")
+
+// ErrBusy is returned when the context is done before the image can be decoded, waiting for other decodes.
+var ErrBusy = errors.New("too many images are being decoded")
+
+// Info is recorded on the asset document of an image.
+type Info struct {
+	Width    int
+	Height   int
+	BlurHash string
+}
+
+// Supported reports whether the content type is an image the pipeline can read.
+func Supported(mimeType string) bool {
+
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+
+	return false
+}
+
+// MIMEType returns the content type of an output format, or "" for an unknown format.
+func MIMEType(format string) string {
+
+	switch format {
+	case JPEG:
+		return "image/jpeg"
+	case PNG:
+		return "image/png"
+	case WebP:
+		return "image/webp"
+	}
+
+	return ""
+}
+
+// Format returns the output format for the content type of an image. Images without a matching output format
+// (gif) are converted to png.
+func Format(mimeType string) string {
+
+	switch mimeType {
+	case "image/jpeg":
+		return JPEG
+	case "image/webp":
+		return WebP
+	}
+
+	return PNG
+}
+
+// Analyze reads the dimensions and computes the blurhash of an image. Images that are too large to decode only
+// have their dimensions.
+func Analyze(ctx context.Context, data []byte) (Info, error) {
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+
+	if err != nil {
+		return Info{}, err
+	}
+
+	info := Info{
+		Width:  config.Width,
+		Height: config.Height,
+	}
+
+	img, release, err := Decode(ctx, data)
+
+	if err == ErrTooLarge {
+		return info, nil
+	}
+
+	if err != nil {
+		return Info{}, err
+	}
+
+	defer release()
+
+	// the hash only has a few components, a small image is enough
+
+	hash, err := blurhash.Encode(4, 3, Resize(img, 32))
+
+	if err != nil {
+		return Info{}, err
+	}
+
+	info.BlurHash = hash
+
+	return info, nil
+}
+
+// Decode decodes an image (jpeg, png, gif or webp). Only the first frame of an animation is decoded. The image
+// counts against the pixels decoded at once until release is called. ErrBusy is returned if ctx is done while waiting
+// for other decodes.
+func Decode(ctx context.Context, data []byte) (img image.Image, release func(), err error) {
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pixels := int64(config.Width) * int64(config.Height)
+
+	if pixels > maxPixels {
+		return nil, nil, ErrTooLarge
+	}
+
+	if err := decoding.Acquire(ctx, pixels); err != nil {
+		return nil, nil, ErrBusy
+	}
+
+	release = func() { decoding.Release(pixels) }
+
+	if img, _, err = image.Decode(bytes.NewReader(data)); err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	return img, release, nil
+}
+
+// Resize scales the image down to the width, keeping the aspect ratio. Images are never scaled up.
+func Resize(img image.Image, width int) image.Image {
+
+	b := img.Bounds()
+
+	if width <= 0 || width >= b.Dx() {
+		return img
+	}
+
+	height := b.Dy() * width / b.Dx()
+
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Rect, img, b, draw.Src, nil)
+
+	return dst
+}
+
+// Encode writes the image in the output format. The encoders do not write any metadata.
+func Encode(w io.Writer, img image.Image, format string) error {
+
+	switch format {
+	case JPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	case PNG:
+		return png.Encode(w, img)
+	case WebP:
+		return EncodeWebP(w, img)
+	}
+
+	return errors.New("unknown image format: " + format)
+}
+
+// Derive decodes an image, scales it down to the width and encodes it in the output format.
+func Derive(ctx context.Context, data []byte, width int, format string) ([]byte, error) {
+
+	img, release, err := Decode(ctx, data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	var buf bytes.Buffer
+
+	if err := Encode(&buf, Resize(img, width), format); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
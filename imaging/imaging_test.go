@@ -0,0 +1,86 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func TestDecodeTooLarge(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, testImage(1, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the header is read: claim 8192 x 8192 pixels in IHDR
+
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], 8192)
+	binary.BigEndian.PutUint32(data[20:], 8192)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	if _, _, err := Decode(context.Background(), data); err != ErrTooLarge {
+		t.Errorf("err = %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestDecodeRelease(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, testImage(16, 16)); err != nil {
+		t.Fatal(err)
+	}
+
+	img, release, err := Decode(context.Background(), buf.Bytes())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Bounds().Dx() != 16 {
+		t.Errorf("width = %v, want 16", img.Bounds().Dx())
+	}
+
+	if decoding.TryAcquire(maxDecoding) {
+		t.Fatal("the pixels of the decoded image are not held")
+	}
+
+	release()
+
+	if !decoding.TryAcquire(maxDecoding) {
+		t.Fatal("the pixels of the decoded image are not released")
+	}
+
+	decoding.Release(maxDecoding)
+}
+
+func TestDecodeBusy(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, testImage(16, 16)); err != nil {
+		t.Fatal(err)
+	}
+
+	// every pixel is held by other decodes: the wait ends with the context
+
+	if !decoding.TryAcquire(maxDecoding) {
+		t.Fatal("pixels are held")
+	}
+
+	defer decoding.Release(maxDecoding)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, _, err := Decode(ctx, buf.Bytes()); err != ErrBusy {
+		t.Errorf("err = %v, want %v", err, ErrBusy)
+	}
+}
@@ -11,11 +11,14 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/duo-labs/webauthn/webauthn"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/lexffe/backend.lexffe.io/audit"
 	"github.com/lexffe/backend.lexffe.io/auth"
 	"github.com/lexffe/backend.lexffe.io/coll"
-	"github.com/patrickmn/go-cache"
+	"github.com/lexffe/backend.lexffe.io/helpers"
+	"github.com/lexffe/backend.lexffe.io/storage"
 	"github.com/pelletier/go-toml"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -33,6 +36,22 @@ type appConfig struct {
 		User     string
 		Pass     string
 	}
+	Auth struct {
+		SessionStore string   `toml:"session_store"`
+		PeerUIDs     []uint32 `toml:"peer_uids"`
+		PeerGIDs     []uint32 `toml:"peer_gids"`
+		PeerRole     string   `toml:"peer_role"`
+		Proxies      []string `toml:"trusted_proxies"`
+		KeySecret    string   `toml:"key_secret"`
+	}
+	Storage struct {
+		LocalRoot string            `toml:"local_root"`
+		S3        storage.S3Config `toml:"s3"`
+	}
+	WebAuthn struct {
+		RPID     string `toml:"rp_id"`
+		RPOrigin string `toml:"rp_origin"`
+	}
 	Web struct {
 		TCP      bool
 		UnixPath string `toml:"unixpath"`
@@ -98,9 +117,30 @@ func main() {
 
 	db := client.Database(conf.Mongo.Database)
 
-	// Auth: API Key cache
+	// Auth: API Key session store, and the counters of the throttle and the webauthn ceremonies next to it
 
-	keycache := cache.New(1*time.Hour, 2*time.Hour)
+	var sessions auth.SessionStore
+	var throttle auth.ThrottleStore
+	var ceremonies auth.CeremonyStore
+
+	switch conf.Auth.SessionStore {
+	case "", "memory":
+		sessions = auth.NewMemoryStore()
+		throttle = auth.NewMemoryThrottleStore()
+		ceremonies = auth.NewMemoryCeremonyStore()
+	case "mongo":
+		if sessions, err = auth.NewMongoStore(ctx, db); err != nil {
+			log.Fatal(err)
+		}
+		if throttle, err = auth.NewMongoThrottleStore(ctx, db); err != nil {
+			log.Fatal(err)
+		}
+		if ceremonies, err = auth.NewMongoCeremonyStore(ctx, db); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unknown session store: %v", conf.Auth.SessionStore)
+	}
 
 	// Webserver: Router
 
@@ -112,57 +152,174 @@ func main() {
 
 	r := gin.Default()
 
-	// Webserver: CORS
+	r.Use(helpers.Deadline(helpers.RequestDeadline))
+
+	// Webserver: address of the client, only forwarded headers of trusted proxies are honoured
+
+	proxies, err := auth.NewProxies(conf.Auth.Proxies)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	r.Use(proxies.Middleware)
+
+	// Webserver: CORS, collections can allow additional origins in their settings
 
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowOrigins = append([]string{}, conf.Meta.CorsHost)
 
-	r.Use(cors.New(corsConfig))
+	// optimistic concurrency of editors in the browser
+	corsConfig.AddAllowHeaders("If-Match")
+	corsConfig.AddExposeHeaders("ETag")
+
+	bootstrapper := &coll.CollectionDelegate{
+		Engine: r,
+		DB:     db,
+		CORS:   corsConfig,
+	}
+
+	r.Use(bootstrapper.CORSMiddleware())
+
+	// Auth: access token signing keys, shared by every instance through the database
+
+	keys, err := auth.NewKeySet(ctx, db, conf.Auth.KeySecret)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	go keys.Run(context.Background())
+
+	// Audit: log of administrative mutations, including users and tokens
+
+	auditLog := &audit.Log{DB: db}
+
+	if err := auditLog.EnsureIndexes(ctx); err != nil {
+		log.Fatal(err)
+	}
 
 	// Webserver: registering authentication routes
 
 	authHandler := auth.AuthenticateHandler{
-		Issuer: conf.Meta.AppName,
-		Cache:  keycache,
+		Issuer:     conf.Meta.AppName,
+		Sessions:   sessions,
+		Throttle:   auth.NewThrottle(throttle),
+		Ceremonies: ceremonies,
+		Keys:       keys,
+		Audit:      auditLog,
+		DB:         db,
+	}
+
+	// Auth: WebAuthn, only enabled if configured
+
+	if conf.WebAuthn.RPID != "" {
+		if authHandler.WebAuthn, err = webauthn.New(&webauthn.Config{
+			RPDisplayName: conf.Meta.AppName,
+			RPID:          conf.WebAuthn.RPID,
+			RPOrigin:      conf.WebAuthn.RPOrigin,
+		}); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Auth: unix socket peers, only trusted if an allow-list is configured
+
+	if len(conf.Auth.PeerUIDs) > 0 || len(conf.Auth.PeerGIDs) > 0 {
+
+		authHandler.Peers = &auth.PeerPolicy{
+			UIDs: conf.Auth.PeerUIDs,
+			GIDs: conf.Auth.PeerGIDs,
+			Role: auth.Role(conf.Auth.PeerRole),
+		}
+
+		switch authHandler.Peers.Role {
+		case "":
+			authHandler.Peers.Role = auth.RoleEditor
+		case auth.RoleAdmin, auth.RoleEditor, auth.RoleViewer:
+		default:
+			log.Fatalf("unknown peer role: %v", conf.Auth.PeerRole)
+		}
 	}
 
 	// Webserver: initialize otp
-	if err := authHandler.OTPInitialization(); err != nil {
+	if err := authHandler.OTPInitialization(ctx); err != nil {
 		log.Fatal(err)
 	}
 
 	r.POST("/auth", authHandler.Handler)
+	r.POST("/auth/refresh", authHandler.RefreshHandler)
+	r.GET("/auth/jwks.json", keys.Handler)
 	r.Use(authHandler.BearerMiddleware)
 
-	// Webserver: Bootstrap Existing collections in database
+	authHandler.RegisterRoutes(r)
 
-	bootstrapper := coll.CollectionDelegate{
-		Engine: r,
-		DB:     db,
+	// Webserver: audit log of administrative mutations
+
+	auditLog.RegisterRoutes(r)
+
+	// Storage: blob stores of asset collections. S3 is only available if configured.
+
+	stores := map[string]storage.Store{}
+
+	if conf.Storage.LocalRoot == "" {
+		conf.Storage.LocalRoot = "assets"
 	}
 
+	if stores[storage.Local], err = storage.NewLocalStore(conf.Storage.LocalRoot); err != nil {
+		log.Fatal(err)
+	}
+
+	if stores[storage.GridFS], err = storage.NewGridFSStore(db); err != nil {
+		log.Fatal(err)
+	}
+
+	if conf.Storage.S3.Endpoint != "" {
+		if stores[storage.S3], err = storage.NewS3Store(ctx, conf.Storage.S3); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Webserver: Bootstrap Existing collections in database
+
+	bootstrapper.Audit = auditLog
+	bootstrapper.Stores = stores
+
 	bootstrapper.RegisterRoutes()
 
 	if err := bootstrapper.Bootstrap(ctx); err != nil {
 		log.Fatal(err)
 	}
 
+	// collections created / deleted by other instances are picked up periodically
+
+	go bootstrapper.Watch(context.Background(), 30*time.Second)
+
+	// scheduled publishing of pages, including the pages missed while the server was down
+
+	go bootstrapper.Schedule(context.Background(), 30*time.Second)
+
 	r.GET("/", func(ctx *gin.Context) {
 		ctx.String(http.StatusOK, "Alive")
 	})
 
 	// Webserver: http server
 
+	// the time to read the body and write the response is limited per request, see helpers.SetDeadline
+
 	srv := &http.Server{
-		Addr:         conf.Web.Port,
-		Handler:      r,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 5 * time.Second,
+		Addr:              conf.Web.Port,
+		Handler:           r,
+		ReadHeaderTimeout: 5 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return auth.ConnContext(helpers.ConnContext(ctx, c), c)
+		},
 	}
 
 	// HTTP Server: peaceful shutdown routine
 
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	// TCP Server
@@ -1,10 +1,39 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 // Asset is any non text-based asset
 type Asset struct {
-	ObjectID  primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
-	MIMEType  string
-	AssetPath string // Path relative to the $(cwd)/assets
+	ObjectID primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+
+	// Name is the original file name of the upload.
+	Name string `json:"name" bson:"name"`
+
+	// MIMEType is sniffed from the content of the file.
+	MIMEType string `json:"mime_type" bson:"mime_type"`
+
+	// Size is the size of the file in bytes.
+	Size int64 `json:"size" bson:"size"`
+
+	// Hash is the hex encoded SHA-256 of the content. The same content is only stored once per collection.
+	Hash string `json:"hash" bson:"hash"`
+
+	// Storage is the blob store the file is kept in, Key is the key of the file in the store.
+	Storage string `json:"storage" bson:"storage"`
+	Key     string `json:"-" bson:"key"`
+
+	// Width, Height and BlurHash are recorded for images.
+	Width    int    `json:"width,omitempty" bson:"width,omitempty"`
+	Height   int    `json:"height,omitempty" bson:"height,omitempty"`
+	BlurHash string `json:"blurhash,omitempty" bson:"blurhash,omitempty"`
+
+	// Derivatives are the keys of the resized / converted images generated from the file.
+	Derivatives []string `json:"-" bson:"derivatives,omitempty"`
+
+	// Created is a timestamp indicating when the asset was uploaded.
+	Created time.Time `json:"created" bson:"created"`
 }
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"errors"
+	"regexp"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -22,6 +24,12 @@ type Page struct {
 	*/
 	SearchableTitle string `json:"searchable_title" bson:"searchable_title"` // Generated Field
 
+	// Slug is set by the editor to override the searchable title generated from the title.
+	Slug string `json:"slug,omitempty" bson:"slug,omitempty"`
+
+	// PreviousSlugs are the previous searchable titles of the page, redirected to the current one. Generated Field
+	PreviousSlugs []string `json:"previous_slugs,omitempty" bson:"previous_slugs,omitempty"`
+
 	// Tags is an array of keywords.
 	Tags []string `json:"tags" bson:"tags" binding:"required"`
 
@@ -43,8 +51,43 @@ type Page struct {
 	// Published is a flag for publisher to withhold the post (drafting).
 	Published bool `json:"published" bson:"published" binding:"required"`
 
+	/*
+		PublishAt and UnpublishAt schedule the page to be published / unpublished at a time.
+
+		Reads honour them as soon as the time has passed, the scheduler then sets Published and clears them.
+		A page with PublishAt in the future is not read as published, even if Published is set.
+	*/
+	PublishAt   *time.Time `json:"publish_at,omitempty" bson:"publish_at,omitempty"`
+	UnpublishAt *time.Time `json:"unpublish_at,omitempty" bson:"unpublish_at,omitempty"`
+
 	// LastUpdated is a timestamp indicating when the document was last edited.
 	LastUpdated time.Time `json:"last_updated" bson:"last_updated"`
 
 	Updated bool `json:"updated" bson:"updated"`
+
+	// Version is incremented on every write, it is the ETag of the page. Generated Field
+	Version int64 `json:"version" bson:"version"`
+}
+
+// slugPattern validates explicit slugs: kebab-case, as generated by helpers.ParseKebab.
+var slugPattern = regexp.MustCompile(`^[a-z0-9_]+(-[a-z0-9_]+)*$`)
+
+// CheckSlug checks that the explicit slug of a page, if any, is in kebab-case.
+func (p Page) CheckSlug() error {
+
+	if p.Slug != "" && !slugPattern.MatchString(p.Slug) {
+		return errors.New("slug should be in kebab-case, e.g. my-first-post")
+	}
+
+	return nil
+}
+
+// CheckSchedule checks that a page is not scheduled to be unpublished before it is published.
+func (p Page) CheckSchedule() error {
+
+	if p.PublishAt != nil && p.UnpublishAt != nil && !p.PublishAt.Before(*p.UnpublishAt) {
+		return errors.New("publish_at should be before unpublish_at")
+	}
+
+	return nil
 }
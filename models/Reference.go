@@ -13,4 +13,7 @@ type Reference struct {
 	InternalCollection string             `json:"collection,omitempty" bson:"collection,omitempty"`
 	InternalObjectID   primitive.ObjectID `json:"internal_id,omitempty" bson:"internal_id,omitempty"`
 	URL                string             `json:"url,omitempty" bson:"url,omitempty"`
+
+	// Version is incremented on every write, it is the ETag of the reference. Generated Field
+	Version int64 `json:"version" bson:"version"`
 }
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Revision is a saved version of a page.
+type Revision struct {
+	ObjectID primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+
+	// Collection and Document identify the page.
+	Collection string             `json:"collection" bson:"collection"`
+	Document   primitive.ObjectID `json:"document" bson:"document"`
+
+	// Number is the number of the revision, the first version of a page is 1.
+	Number int `json:"rev" bson:"rev"`
+
+	// Restored is the number of the revision this one restored, if any.
+	Restored int `json:"restored,omitempty" bson:"restored,omitempty"`
+
+	// Time is when the version was saved, Author who saved it.
+	Time   time.Time `json:"time" bson:"time"`
+	Author string    `json:"author,omitempty" bson:"author,omitempty"`
+
+	// Page is the page as saved.
+	Page Page `json:"page" bson:"page"`
+}
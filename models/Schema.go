@@ -0,0 +1,63 @@
+package models
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Schema is a JSON Schema document. It is stored as a string, as its keywords ($ref, $schema) are not valid
+// mongo field names.
+type Schema []byte
+
+// MarshalJSON returns the schema as it is.
+func (s Schema) MarshalJSON() ([]byte, error) {
+
+	if len(s) == 0 {
+		return []byte("null"), nil
+	}
+
+	return s, nil
+}
+
+// UnmarshalJSON keeps a copy of the schema.
+func (s *Schema) UnmarshalJSON(data []byte) error {
+
+	if string(data) == "null" {
+		*s = nil
+		return nil
+	}
+
+	*s = append((*s)[0:0], data...)
+
+	return nil
+}
+
+// MarshalBSONValue stores the schema as a string.
+func (s Schema) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bsontype.String, bsoncore.AppendString(nil, string(s)), nil
+}
+
+// UnmarshalBSONValue reads the schema from a string.
+func (s *Schema) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+
+	if t == bsontype.Null {
+		*s = nil
+		return nil
+	}
+
+	if t != bsontype.String {
+		return errors.New("schema is not a string")
+	}
+
+	str, _, ok := bsoncore.ReadString(data)
+
+	if !ok {
+		return errors.New("malformed schema")
+	}
+
+	*s = Schema(str)
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// sortPattern validates the sort field, optionally prefixed with "-" for descending order.
+var sortPattern = regexp.MustCompile(`^-?[A-Za-z_][\w.]*$`)
+
+// markdown rendering profiles of pages, see helpers.ParseMDProfile.
+const (
+	MarkdownUGC     = "ugc"
+	MarkdownStrict  = "strict"
+	MarkdownTrusted = "trusted"
+)
+
+// CollectionSettings configure the behaviour of a collection. Zero values are the defaults.
+type CollectionSettings struct {
+	// DefaultLimit is the page size of requests without a limit, MaxLimit caps the page size. 0 is unlimited.
+	DefaultLimit int64 `json:"default_limit,omitempty" bson:"default_limit,omitempty"`
+	MaxLimit     int64 `json:"max_limit,omitempty" bson:"max_limit,omitempty"`
+
+	// Sort is the field documents are listed by, descending if prefixed with "-". Defaults to "-_id" (newest first).
+	Sort string `json:"sort,omitempty" bson:"sort,omitempty"`
+
+	// Private collections can only be read with the read:<collection> scope.
+	Private bool `json:"private,omitempty" bson:"private,omitempty"`
+
+	// CORSOrigins are allowed to request the collection, in addition to the cors_host of the configuration.
+	CORSOrigins []string `json:"cors_origins,omitempty" bson:"cors_origins,omitempty"`
+
+	// Markdown is the rendering profile of pages: "ugc" (default), "strict" or "trusted".
+	Markdown string `json:"markdown,omitempty" bson:"markdown,omitempty"`
+
+	// RequireIfMatch rejects updates and deletions of pages and references without an If-Match header.
+	RequireIfMatch bool `json:"require_if_match,omitempty" bson:"require_if_match,omitempty"`
+}
+
+// Validate checks the settings.
+func (s CollectionSettings) Validate() error {
+
+	if s.DefaultLimit < 0 || s.MaxLimit < 0 {
+		return errors.New("limits cannot be negative")
+	}
+
+	if s.MaxLimit > 0 && s.DefaultLimit > s.MaxLimit {
+		return errors.New("default_limit cannot be above max_limit")
+	}
+
+	if s.Sort != "" && !sortPattern.MatchString(s.Sort) {
+		return errors.New("invalid sort field: " + s.Sort)
+	}
+
+	for _, origin := range s.CORSOrigins {
+		if !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			return errors.New("invalid cors origin: " + origin)
+		}
+	}
+
+	switch s.Markdown {
+	case "", MarkdownUGC, MarkdownStrict, MarkdownTrusted:
+	default:
+		return errors.New("unknown markdown profile: " + s.Markdown)
+	}
+
+	return nil
+}
+
+// Limit returns the page size of a request with the limit (0 if none).
+func (s CollectionSettings) Limit(requested int64) int64 {
+
+	limit := requested
+
+	if limit <= 0 {
+		limit = s.DefaultLimit
+	}
+
+	if s.MaxLimit > 0 && (limit <= 0 || limit > s.MaxLimit) {
+		limit = s.MaxLimit
+	}
+
+	return limit
+}
+
+// SortOrder returns the sort of listings. _id is the tie breaker, for stable pagination.
+func (s CollectionSettings) SortOrder() bson.D {
+
+	field, order := strings.TrimPrefix(s.Sort, "-"), 1
+
+	if s.Sort == "" {
+		field, order = "_id", -1
+	} else if strings.HasPrefix(s.Sort, "-") {
+		order = -1
+	}
+
+	sort := bson.D{{Key: field, Value: order}}
+
+	if field != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: -1})
+	}
+
+	return sort
+}
@@ -6,4 +6,7 @@ const (
 	TypePage  ObjectType = "page"
 	TypeRef   ObjectType = "reference"
 	TypeAsset ObjectType = "asset"
+
+	// TypeCustom documents have a user-defined shape, described by the JSON Schema of the collection.
+	TypeCustom ObjectType = "custom"
 )
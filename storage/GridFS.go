@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// gridFSBucket is the name of the bucket, i.e. the files are kept in assets.files and assets.chunks.
+const gridFSBucket = "assets"
+
+// GridFSStore keeps files in the mongo database, with GridFS. The key is the file name.
+type GridFSStore struct {
+	Bucket *gridfs.Bucket
+
+	// Chunks is the chunks collection of the bucket, files are read from it directly, see gridFSObject.
+	Chunks *mongo.Collection
+}
+
+// NewGridFSStore opens the bucket in the database.
+func NewGridFSStore(db *mongo.Database) (*GridFSStore, error) {
+
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(gridFSBucket))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &GridFSStore{Bucket: bucket, Chunks: db.Collection(gridFSBucket + ".chunks")}, nil
+}
+
+// gridFSFile is the document of a file in <bucket>.files
+type gridFSFile struct {
+	ID         interface{} `bson:"_id"`
+	Length     int64       `bson:"length"`
+	ChunkSize  int64       `bson:"chunkSize"`
+	UploadDate time.Time   `bson:"uploadDate"`
+}
+
+// gridFSChunk is the document of a chunk in <bucket>.chunks
+type gridFSChunk struct {
+	N    int64  `bson:"n"`
+	Data []byte `bson:"data"`
+}
+
+// files finds the files with the key, newest first.
+func (g *GridFSStore) files(ctx context.Context, key string) ([]gridFSFile, error) {
+
+	cur, err := g.Bucket.Find(bson.M{"filename": key}, options.GridFSFind().SetSort(bson.M{"uploadDate": -1}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var files []gridFSFile
+
+	if err := cur.All(ctx, &files); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// Put implements Store. GridFS allows files with the same name, so previous files with the key are removed
+// after the upload.
+func (g *GridFSStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+
+	previous, err := g.files(ctx, key)
+
+	if err != nil {
+		return err
+	}
+
+	opts := options.GridFSUpload().SetMetadata(bson.M{"content_type": contentType})
+
+	if _, err := g.Bucket.UploadFromStream(key, r, opts); err != nil {
+		return err
+	}
+
+	for _, f := range previous {
+		if err := g.Bucket.Delete(f.ID); err != nil && err != gridfs.ErrFileNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Open implements Store. The chunks are streamed as they are read, download streams of the driver cannot seek.
+func (g *GridFSStore) Open(ctx context.Context, key string) (Object, error) {
+
+	files, err := g.files(ctx, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, ErrNotFound
+	}
+
+	if files[0].ChunkSize <= 0 {
+		return nil, errors.New("gridfs: invalid chunk size")
+	}
+
+	return &gridFSObject{ctx: ctx, chunks: g.Chunks, file: files[0]}, nil
+}
+
+// Delete implements Store.
+func (g *GridFSStore) Delete(ctx context.Context, key string) error {
+
+	files, err := g.files(ctx, key)
+
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if err := g.Bucket.Delete(f.ID); err != nil && err != gridfs.ErrFileNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// gridFSObject reads a file chunk by chunk. Seeking is free until the next read, which restarts the cursor at the
+// chunk of the offset if it is not the next one, e.g. for range requests.
+type gridFSObject struct {
+	ctx    context.Context
+	chunks *mongo.Collection
+	file   gridFSFile
+
+	// pos is the offset of the next read.
+	pos int64
+
+	cur *mongo.Cursor
+
+	// buf is the current chunk, starting at offset start of the file.
+	buf   []byte
+	start int64
+}
+
+// Read implements io.Reader.
+func (o *gridFSObject) Read(p []byte) (int, error) {
+
+	if o.pos >= o.file.Length {
+		return 0, io.EOF
+	}
+
+	if o.pos < o.start || o.pos >= o.start+int64(len(o.buf)) {
+		if err := o.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, o.buf[o.pos-o.start:])
+	o.pos += int64(n)
+
+	return n, nil
+}
+
+// next reads the chunk of the current offset, from the open cursor if it is the next one.
+func (o *gridFSObject) next() error {
+
+	n := o.pos / o.file.ChunkSize
+
+	if o.cur == nil || n != o.start/o.file.ChunkSize+1 {
+
+		if o.cur != nil {
+			o.cur.Close(o.ctx)
+		}
+
+		cur, err := o.chunks.Find(o.ctx, bson.M{
+			"files_id": o.file.ID,
+			"n":        bson.M{"$gte": n},
+		}, options.Find().SetSort(bson.M{"n": 1}))
+
+		if err != nil {
+			return err
+		}
+
+		o.cur = cur
+	}
+
+	if !o.cur.Next(o.ctx) {
+		if err := o.cur.Err(); err != nil {
+			return err
+		}
+		return io.ErrUnexpectedEOF
+	}
+
+	var chunk gridFSChunk
+
+	if err := o.cur.Decode(&chunk); err != nil {
+		return err
+	}
+
+	if chunk.N != n {
+		return errors.New("gridfs: missing chunk")
+	}
+
+	o.buf = chunk.Data
+	o.start = chunk.N * o.file.ChunkSize
+
+	if o.pos >= o.start+int64(len(o.buf)) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return nil
+}
+
+// Seek implements io.Seeker.
+func (o *gridFSObject) Seek(offset int64, whence int) (int64, error) {
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.pos
+	case io.SeekEnd:
+		offset += o.file.Length
+	default:
+		return 0, errors.New("gridfs: invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("gridfs: negative position")
+	}
+
+	o.pos = offset
+
+	return offset, nil
+}
+
+// Close implements io.Closer.
+func (o *gridFSObject) Close() error {
+
+	if o.cur == nil {
+		return nil
+	}
+
+	return o.cur.Close(o.ctx)
+}
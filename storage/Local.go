@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// LocalStore keeps files in a directory.
+type LocalStore struct {
+	// Root is the directory, e.g. $(cwd)/assets
+	Root string
+}
+
+// NewLocalStore creates the directory if it does not exist.
+func NewLocalStore(root string) (*LocalStore, error) {
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	return &LocalStore{Root: root}, nil
+}
+
+// path resolves the key inside the root. Keys cannot escape the root.
+func (l *LocalStore) path(key string) (string, error) {
+
+	clean := filepath.Clean("/" + key)
+
+	if clean == "/" {
+		return "", errors.New("invalid key: " + key)
+	}
+
+	return filepath.Join(l.Root, clean), nil
+}
+
+// Put implements Store. The file is written to a temporary file first, so that it is never read half written.
+func (l *LocalStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+
+	path, err := l.path(key)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".upload-")
+
+	if err != nil {
+		return err
+	}
+
+	// removing fails harmlessly once the file has been renamed
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Open implements Store.
+func (l *LocalStore) Open(ctx context.Context, key string) (Object, error) {
+
+	path, err := l.path(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Delete implements Store.
+func (l *LocalStore) Delete(ctx context.Context, key string) error {
+
+	path, err := l.path(key)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestLocalStore(t *testing.T) (*LocalStore, string) {
+
+	dir, err := ioutil.TempDir("", "storage-")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	root := filepath.Join(dir, "assets")
+
+	l, err := NewLocalStore(root)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l, root
+}
+
+// readAll opens and reads a file of the store.
+func readAll(t *testing.T, s Store, key string) string {
+
+	f, err := s.Open(context.Background(), key)
+
+	if err != nil {
+		t.Fatalf("open %v: %v", key, err)
+	}
+
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+
+	if err != nil {
+		t.Fatalf("read %v: %v", key, err)
+	}
+
+	return string(content)
+}
+
+func TestLocalStorePutOpen(t *testing.T) {
+
+	l, root := newTestLocalStore(t)
+	ctx := context.Background()
+
+	if err := l.Put(ctx, "images/abc", strings.NewReader("hello"), 5, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readAll(t, l, "images/abc"); got != "hello" {
+		t.Errorf("content = %q, want %q", got, "hello")
+	}
+
+	// replacing
+
+	if err := l.Put(ctx, "images/abc", strings.NewReader("world!"), 6, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readAll(t, l, "images/abc"); got != "world!" {
+		t.Errorf("replaced content = %q, want %q", got, "world!")
+	}
+
+	// no temporary files are left behind
+
+	entries, err := ioutil.ReadDir(filepath.Join(root, "images"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Errorf("files in directory = %v, want 1", len(entries))
+	}
+}
+
+func TestLocalStoreSeek(t *testing.T) {
+
+	l, _ := newTestLocalStore(t)
+
+	if err := l.Put(context.Background(), "a", strings.NewReader("0123456789"), 10, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := l.Open(context.Background(), "a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	if n, err := f.Seek(-4, io.SeekEnd); err != nil || n != 6 {
+		t.Fatalf("seek = %v, %v, want 6", n, err)
+	}
+
+	rest, _ := ioutil.ReadAll(f)
+
+	if string(rest) != "6789" {
+		t.Errorf("content after seek = %q, want %q", rest, "6789")
+	}
+}
+
+func TestLocalStoreNotFound(t *testing.T) {
+
+	l, _ := newTestLocalStore(t)
+
+	if _, err := l.Open(context.Background(), "missing"); err != ErrNotFound {
+		t.Errorf("err = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestLocalStoreDelete(t *testing.T) {
+
+	l, _ := newTestLocalStore(t)
+	ctx := context.Background()
+
+	if err := l.Put(ctx, "a", strings.NewReader("a"), 1, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.Open(ctx, "a"); err != ErrNotFound {
+		t.Errorf("deleted file: err = %v, want %v", err, ErrNotFound)
+	}
+
+	// deleting a missing file is not an error
+
+	if err := l.Delete(ctx, "a"); err != nil {
+		t.Errorf("deleting a missing file: %v", err)
+	}
+}
+
+func TestLocalStoreKeysStayInRoot(t *testing.T) {
+
+	l, root := newTestLocalStore(t)
+	ctx := context.Background()
+
+	if err := l.Put(ctx, "../../escaped", strings.NewReader("x"), 1, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "escaped")); err != nil {
+		t.Errorf("file is not in the root: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(root), "escaped")); !os.IsNotExist(err) {
+		t.Errorf("file escaped the root")
+	}
+
+	for _, key := range []string{"", "/", ".."} {
+		if err := l.Put(ctx, key, strings.NewReader("x"), 1, "text/plain"); err == nil {
+			t.Errorf("key %q: no error", key)
+		}
+	}
+}
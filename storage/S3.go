@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config configures an S3-compatible object store (e.g. AWS S3, MinIO).
+type S3Config struct {
+	Endpoint  string `toml:"endpoint"`
+	Region    string `toml:"region"`
+	Bucket    string `toml:"bucket"`
+	AccessKey string `toml:"access_key"`
+	SecretKey string `toml:"secret_key"`
+	SSL       bool   `toml:"ssl"`
+}
+
+// S3Store keeps files in a bucket of an S3-compatible object store. The key is the object name.
+type S3Store struct {
+	Client *minio.Client
+	Bucket string
+}
+
+// NewS3Store connects to the object store, and creates the bucket if it does not exist.
+func NewS3Store(ctx context.Context, conf S3Config) (*S3Store, error) {
+
+	client, err := minio.New(conf.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(conf.AccessKey, conf.SecretKey, ""),
+		Secure: conf.SSL,
+		Region: conf.Region,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(ctx, conf.Bucket)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		if err := client.MakeBucket(ctx, conf.Bucket, minio.MakeBucketOptions{Region: conf.Region}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &S3Store{Client: client, Bucket: conf.Bucket}, nil
+}
+
+// Put implements Store.
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.Client.PutObject(ctx, s.Bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+// Open implements Store. Objects are fetched lazily, seeking issues range requests.
+func (s *S3Store) Open(ctx context.Context, key string) (Object, error) {
+
+	obj, err := s.Client.GetObject(ctx, s.Bucket, key, minio.GetObjectOptions{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// GetObject does not fail for missing objects, Stat does
+
+	if _, err := obj.Stat(); err != nil {
+
+		obj.Close()
+
+		if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+// Delete implements Store.
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	return s.Client.RemoveObject(ctx, s.Bucket, key, minio.RemoveObjectOptions{})
+}
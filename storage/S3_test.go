@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is a stand-in for an S3-compatible object store, with path-style buckets and no authentication.
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket := path[0]
+
+	objects, exists := f.buckets[bucket]
+
+	// bucket requests
+
+	if len(path) == 1 || path[1] == "" {
+		switch r.Method {
+		case http.MethodHead:
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			f.buckets[bucket] = map[string][]byte{}
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+		return
+	}
+
+	if !exists {
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	key := path[1]
+
+	switch r.Method {
+	case http.MethodPut:
+		content, err := readPayload(r)
+
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+
+		objects[key] = content
+		w.Header().Set("ETag", `"`+strconv.Itoa(len(content))+`"`)
+
+	case http.MethodGet, http.MethodHead:
+		content, exists := objects[key]
+
+		if !exists {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+
+		w.Header().Set("ETag", `"`+strconv.Itoa(len(content))+`"`)
+		http.ServeContent(w, r, key, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), bytes.NewReader(content))
+
+	case http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// readPayload reads the body of a PUT, decoding the chunks of streaming signatures.
+func readPayload(r *http.Request) ([]byte, error) {
+
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return ioutil.ReadAll(r.Body)
+	}
+
+	// <hex size>;chunk-signature=<signature>\r\n<data>\r\n ... 0;chunk-signature=<signature>\r\n\r\n
+
+	var content bytes.Buffer
+
+	body := bufio.NewReader(r.Body)
+
+	for {
+		line, err := body.ReadString('\n')
+
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.ParseInt(strings.SplitN(strings.TrimSpace(line), ";", 2)[0], 16, 64)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if size == 0 {
+			return content.Bytes(), nil
+		}
+
+		if _, err := io.CopyN(&content, body, size); err != nil {
+			return nil, err
+		}
+
+		if _, err := body.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>`+code+`</Code><Message>`+code+`</Message></Error>`)
+}
+
+func newTestS3Store(t *testing.T) (*S3Store, *fakeS3) {
+
+	fake := &fakeS3{buckets: map[string]map[string][]byte{}}
+	server := httptest.NewServer(fake)
+
+	t.Cleanup(server.Close)
+
+	s, err := NewS3Store(context.Background(), S3Config{
+		Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		Region:    "us-east-1",
+		Bucket:    "assets",
+		AccessKey: "access",
+		SecretKey: "secret",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, fake
+}
+
+func TestS3StoreCreatesBucket(t *testing.T) {
+
+	_, fake := newTestS3Store(t)
+
+	if _, exists := fake.buckets["assets"]; !exists {
+		t.Errorf("bucket was not created")
+	}
+}
+
+func TestS3StorePutOpen(t *testing.T) {
+
+	s, fake := newTestS3Store(t)
+	ctx := context.Background()
+
+	if err := s.Put(ctx, "images/abc", strings.NewReader("0123456789"), 10, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(fake.buckets["assets"]["images/abc"]); got != "0123456789" {
+		t.Errorf("stored content = %q, want %q", got, "0123456789")
+	}
+
+	if got := readAll(t, s, "images/abc"); got != "0123456789" {
+		t.Errorf("content = %q, want %q", got, "0123456789")
+	}
+
+	// seeking issues range requests
+
+	f, err := s.Open(ctx, "images/abc")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	if n, err := f.Seek(-4, io.SeekEnd); err != nil || n != 6 {
+		t.Fatalf("seek = %v, %v, want 6", n, err)
+	}
+
+	rest, _ := ioutil.ReadAll(f)
+
+	if string(rest) != "6789" {
+		t.Errorf("content after seek = %q, want %q", rest, "6789")
+	}
+}
+
+func TestS3StoreNotFound(t *testing.T) {
+
+	s, _ := newTestS3Store(t)
+
+	if _, err := s.Open(context.Background(), "missing"); err != ErrNotFound {
+		t.Errorf("err = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestS3StoreDelete(t *testing.T) {
+
+	s, _ := newTestS3Store(t)
+	ctx := context.Background()
+
+	if err := s.Put(ctx, "a", strings.NewReader("a"), 1, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Open(ctx, "a"); err != ErrNotFound {
+		t.Errorf("deleted file: err = %v, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+/**
+This package contains the blob stores the files of assets are kept in.
+
+Files are addressed by a key, e.g. <collection>/<sha256>. Every asset collection chooses its store
+in the meta document, every asset records the store its file was written to.
+*/
+
+// ErrNotFound is returned by a Store if there is no file with the key.
+var ErrNotFound = errors.New("file not found")
+
+// Object is an opened file. It is seekable, for range requests.
+type Object interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
+// Store persists files.
+type Store interface {
+	// Put stores a file of the size, replacing an existing file with the key.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+
+	// Open opens a file.
+	Open(ctx context.Context, key string) (Object, error)
+
+	// Delete removes a file. Removing a file that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+const (
+	// Local stores files on the local filesystem.
+	Local = "local"
+	// GridFS stores files in the mongo database.
+	GridFS = "gridfs"
+	// S3 stores files in an S3-compatible object store.
+	S3 = "s3"
+)